@@ -0,0 +1,130 @@
+package core
+
+import (
+	"math"
+	"pmvs/lens"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	// reverses the order of rows or columns
+	flip3 = mat.NewDense(3, 3, []float64{
+		0, 0, 1,
+		0, 1, 0,
+		1, 0, 0,
+	})
+)
+
+// decompose : Decomposes the projection matrix into s * K [R | T] where K is
+// upper triangular with positive diagonal and K(2, 2) = 1, R is a rotation
+// and s is a non zero scale whose sign is kept in scaleSign
+func (camera *Camera) decompose() {
+	m := mat.DenseCopyOf(camera.ProjMat.Slice(0, 3, 0, 3))
+	p4 := mat.VecDenseCopyOf(camera.ProjMat.ColView(3))
+
+	// RQ decomposition through the QR decomposition of the flipped matrix:
+	// if (flip * M)^T = Q U then M = (flip U^T flip) (flip Q^T)
+	flipped := mat.NewDense(3, 3, nil)
+	flipped.Mul(flip3, m)
+	var qr mat.QR
+	qr.Factorize(flipped.T())
+	var q, u mat.Dense
+	qr.QTo(&q)
+	qr.RTo(&u)
+
+	k, r := mat.NewDense(3, 3, nil), mat.NewDense(3, 3, nil)
+	k.Product(flip3, u.T(), flip3)
+	r.Mul(flip3, q.T())
+
+	// make the diagonal of K positive
+	for i := 0; i < 3; i++ {
+		if k.At(i, i) < 0 {
+			for j := 0; j < 3; j++ {
+				k.Set(j, i, -k.At(j, i))
+				r.Set(i, j, -r.At(i, j))
+			}
+		}
+	}
+	// a reflection means the matrix was given with a negative scale, the
+	// projection of -P is the same
+	camera.scaleSign = 1
+	if mat.Det(r) < 0 {
+		r.Scale(-1, r)
+		p4.ScaleVec(-1, p4)
+		camera.scaleSign = -1
+	}
+
+	t := mat.NewVecDense(3, nil)
+	t.SolveVec(k, p4)
+	k.Scale(1/k.At(2, 2), k)
+	camera.K, camera.R, camera.T = k, r, t
+}
+
+// InFront : Returns whether the homogeneous point is in front of the camera
+func (camera *Camera) InFront(point *mat.VecDense) bool {
+	depth := mat.Dot(camera.ProjMat.RowView(2), point)
+	return depth*camera.scaleSign*point.AtVec(3) > 0
+}
+
+// ViewDirection : Returns the unit vector along which the camera looks
+func (camera *Camera) ViewDirection() *mat.VecDense {
+	return mat.NewVecDense(4, []float64{
+		camera.R.At(2, 0), camera.R.At(2, 1), camera.R.At(2, 2), 0,
+	})
+}
+
+// FocalLength : Returns the focal lengths in pixels along x and y
+func (camera *Camera) FocalLength() (fx, fy float64) {
+	return camera.K.At(0, 0), camera.K.At(1, 1)
+}
+
+// PrincipalPoint : Returns the projection of the optical axis in pixels
+func (camera *Camera) PrincipalPoint() (cx, cy float64) {
+	return camera.K.At(0, 2), camera.K.At(1, 2)
+}
+
+// FieldOfView : Returns the horizontal and vertical field of view, in
+// radians, of an image of the given size taken by the camera
+func (camera *Camera) FieldOfView(width, height int) (horizontal, vertical float64) {
+	fx, fy := camera.FocalLength()
+	cx, cy := camera.PrincipalPoint()
+	horizontal = math.Atan(cx/fx) + math.Atan((float64(width)-1-cx)/fx)
+	vertical = math.Atan(cy/fy) + math.Atan((float64(height)-1-cy)/fy)
+	return
+}
+
+// FieldOfView : Returns the horizontal and vertical field of view in radians
+func (photo *Photo) FieldOfView() (horizontal, vertical float64) {
+	return photo.Cam.FieldOfView(photo.Img.Width, photo.Img.Height)
+}
+
+// Intrinsics : Returns the pinhole parameters of the camera
+func (camera *Camera) Intrinsics() lens.Intrinsics {
+	fx, fy := camera.FocalLength()
+	cx, cy := camera.PrincipalPoint()
+	return lens.Intrinsics{Fx: fx, Fy: fy, Cx: cx, Cy: cy}
+}
+
+// Undistort : Removes the lens distortion from the image and mask of the
+// photo, the projection matrix is assumed to describe the undistorted
+// pinhole camera. Features should be detected afterwards
+func (photo *Photo) Undistort(model lens.Model) {
+	if model == nil {
+		return
+	}
+	photo.Img, photo.Mask = lens.Undistort(photo.Img, photo.Mask,
+		photo.Cam.Intrinsics(), model)
+	photo.resetDerived()
+}
+
+// Undistort : Undistorts every photo with the model of the same index, nil
+// models are skipped
+func (imgsManager *ImagesManager) Undistort(models []lens.Model) {
+	if len(models) != len(imgsManager.Photos) {
+		panic("Number of distortion models and photos aren't equal")
+	}
+	for i, photo := range imgsManager.Photos {
+		photo.Undistort(models[i])
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// rotationXYZ : Returns the rotation by az about z, then ay about y, then ax
+// about x
+func rotationXYZ(ax, ay, az float64) *mat.Dense {
+	rx := mat.NewDense(3, 3, []float64{
+		1, 0, 0,
+		0, math.Cos(ax), -math.Sin(ax),
+		0, math.Sin(ax), math.Cos(ax),
+	})
+	ry := mat.NewDense(3, 3, []float64{
+		math.Cos(ay), 0, math.Sin(ay),
+		0, 1, 0,
+		-math.Sin(ay), 0, math.Cos(ay),
+	})
+	rz := mat.NewDense(3, 3, []float64{
+		math.Cos(az), -math.Sin(az), 0,
+		math.Sin(az), math.Cos(az), 0,
+		0, 0, 1,
+	})
+	r := mat.NewDense(3, 3, nil)
+	r.Product(rx, ry, rz)
+	return r
+}
+
+// composeCamera : Returns s * K [R | t] in row-major
+func composeCamera(s float64, k, r *mat.Dense, t *mat.VecDense) []float64 {
+	rt := mat.NewDense(3, 4, nil)
+	rt.Slice(0, 3, 0, 3).(*mat.Dense).Copy(r)
+	rt.Slice(0, 3, 3, 4).(*mat.Dense).Copy(t)
+	proj := mat.NewDense(3, 4, nil)
+	proj.Mul(k, rt)
+	proj.Scale(s, proj)
+	return proj.RawMatrix().Data
+}
+
+func TestCameraDecompose(t *testing.T) {
+	k := mat.NewDense(3, 3, []float64{
+		500, 2, 320,
+		0, 480, 240,
+		0, 0, 1,
+	})
+	rot := rotationXYZ(0.3, -0.7, 1.2)
+	trans := mat.NewVecDense(3, []float64{0.3, -0.2, 4})
+	negTrans := mat.NewVecDense(3, nil)
+	negTrans.ScaleVec(-1, trans)
+	// a reflection of the rotation, the decomposition can't tell it apart
+	// from -R with a negative scale
+	reflected := mat.NewDense(3, 3, nil)
+	reflected.Mul(mat.NewDense(3, 3, []float64{1, 0, 0, 0, -1, 0, 0, 0, 1}), rot)
+	negReflected := mat.NewDense(3, 3, nil)
+	negReflected.Scale(-1, reflected)
+
+	tests := []struct {
+		name      string
+		projMat   []float64
+		wantR     *mat.Dense
+		wantT     *mat.VecDense
+		wantScale float64
+	}{
+		{"positive scale", composeCamera(2, k, rot, trans), rot, trans, 1},
+		{"negative scale", composeCamera(-0.5, k, rot, trans), rot, trans, -1},
+		{"reflected rotation", composeCamera(3, k, reflected, trans), negReflected, negTrans, -1},
+		{"reflected rotation and negative scale", composeCamera(-3, k, reflected, trans),
+			negReflected, negTrans, 1},
+	}
+	for _, test := range tests {
+		camera := newCamera(test.projMat)
+		for i := 0; i < 3; i++ {
+			for j := 0; j < i; j++ {
+				if camera.K.At(i, j) != 0 {
+					t.Errorf("%v: K isn't upper triangular: %v", test.name, mat.Formatted(camera.K))
+				}
+			}
+			if camera.K.At(i, i) <= 0 {
+				t.Errorf("%v: K has a non positive diagonal: %v", test.name, mat.Formatted(camera.K))
+			}
+		}
+		if camera.K.At(2, 2) != 1 {
+			t.Errorf("%v: got K(2, 2) = %v, want 1", test.name, camera.K.At(2, 2))
+		}
+		if !mat.EqualApprox(camera.K, k, 1e-9) {
+			t.Errorf("%v: got K %v, want %v", test.name, mat.Formatted(camera.K), mat.Formatted(k))
+		}
+		var rtr mat.Dense
+		rtr.Mul(camera.R.T(), camera.R)
+		if !mat.EqualApprox(&rtr, eye3, 1e-12) || math.Abs(mat.Det(camera.R)-1) > 1e-12 {
+			t.Errorf("%v: R isn't a rotation: %v", test.name, mat.Formatted(camera.R))
+		}
+		if !mat.EqualApprox(camera.R, test.wantR, 1e-12) ||
+			!mat.EqualApprox(camera.T, test.wantT, 1e-9) {
+			t.Errorf("%v: got R %v and T %v, want %v and %v", test.name,
+				mat.Formatted(camera.R), mat.Formatted(camera.T.T()),
+				mat.Formatted(test.wantR), mat.Formatted(test.wantT.T()))
+		}
+		if camera.scaleSign != test.wantScale {
+			t.Errorf("%v: got scale sign %v, want %v", test.name, camera.scaleSign, test.wantScale)
+		}
+
+		// points at both sides of the camera along its optical axis, also
+		// with a negative homogeneous coordinate
+		for _, depth := range []float64{-3, -0.1, 0.1, 3} {
+			for _, w := range []float64{1, -2} {
+				point := mat.NewVecDense(4, nil)
+				for i := 0; i < 3; i++ {
+					point.SetVec(i, w*(camera.OpticalCenter.AtVec(i)+
+						depth*camera.OpticalAxis.AtVec(i)))
+				}
+				point.SetVec(3, w)
+				if got := camera.InFront(point); got != (depth > 0) {
+					t.Errorf("%v: the point at depth %v with w = %v is in front: %v",
+						test.name, depth, w, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"pmvs/featdetect"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// Phase : A step of the reconstruction
+type Phase int
+
+const (
+	// PhaseInitialMatching : Patches are created from matched features,
+	// the iteration is the id of the next photo to match
+	PhaseInitialMatching Phase = iota
+	// PhaseClusters : Patches are reconstructed cluster by cluster, the
+	// iteration is the number of clusters done
+	PhaseClusters
+	// PhaseCompleted : Every step of the reconstruction is done
+	PhaseCompleted
+)
+
+const (
+	checkpointMagic   = "PMVSCKPT"
+	checkpointVersion = uint32(1)
+)
+
+var (
+	errNotCheckpoint        = errors.New("Error! File is not a checkpoint")
+	errCheckpointVersion    = errors.New("Error! Checkpoint version is not supported")
+	errCheckpointMismatch   = errors.New("Error! Checkpoint doesn't match the photos")
+	errCheckpointMalformed  = errors.New("Error! Checkpoint is malformed")
+	errCheckpointForCluster = errors.New("Error! Checkpoints of clusters aren't supported")
+)
+
+func init() {
+	// regions are stored in the options as interfaces
+	gob.Register(new(AxisAlignedBox))
+	gob.Register(new(OrientedBox))
+	gob.Register(new(ConvexPolyhedron))
+}
+
+// checkpointState : Everything needed to resume a reconstruction
+type checkpointState struct {
+	Phase         Phase
+	Iteration     int
+	Options       *Options
+	Photos        []checkpointPhoto
+	Patches       []checkpointPatch
+	RejectedByROI int
+}
+
+// checkpointPhoto : The features of a photo and the occupied cells, cell
+// CellIndices[i], counted row by row, holds patch CellPatches[i]
+type checkpointPhoto struct {
+	Width       int
+	Height      int
+	Feats       [][]*featdetect.Feature
+	CellIndices []int
+	CellPatches []int
+}
+
+type checkpointPatch struct {
+	ID       int
+	Center   [4]float64
+	Normal   [4]float64
+	RefPhoto int
+	VPhotos  []int
+	TPhotos  []int
+	Score    float64
+	Size     float64
+	Scale    float64
+	Status   optimize.Status
+}
+
+// SaveCheckpoint : Writes the state of the reconstruction, the features of
+// every photo, the patches, the cells they occupy, the current phase and
+// iteration and the options, to path. The file is written next to path
+// then renamed so that a crash never leaves a partial checkpoint. Region
+// types defined outside the package should be registered with gob.Register
+func (imgsManager *ImagesManager) SaveCheckpoint(path string) (err error) {
+	if imgsManager.isCluster {
+		return errCheckpointForCluster
+	}
+	state := checkpointState{
+		Phase:         imgsManager.Phase,
+		Iteration:     imgsManager.Iteration,
+		Options:       options,
+		Photos:        make([]checkpointPhoto, len(imgsManager.Photos)),
+		RejectedByROI: imgsManager.RejectedByROI,
+	}
+	indices := make(map[*Patch]int, len(imgsManager.Patches))
+	for _, patch := range imgsManager.Patches {
+		if patch == nil {
+			continue
+		}
+		indices[patch] = len(state.Patches)
+		state.Patches = append(state.Patches, checkpointPatch{})
+		saved := &state.Patches[len(state.Patches)-1]
+		saved.ID = patch.ID
+		for j := 0; j < 4; j++ {
+			saved.Center[j], saved.Normal[j] = patch.Center.AtVec(j), patch.Normal.AtVec(j)
+		}
+		saved.RefPhoto, saved.VPhotos, saved.TPhotos = patch.RefPhoto, patch.VPhotos, patch.TPhotos
+		saved.Score, saved.Size, saved.Scale, saved.Status = patch.Score, patch.Size, patch.Scale, patch.Status
+	}
+	for i, photo := range imgsManager.Photos {
+		saved := &state.Photos[i]
+		saved.Width, saved.Height = photo.Img.Width, photo.Img.Height
+		saved.Feats = photo.Feats
+		cellsWidth := len(photo.Cells[0])
+		for y, row := range photo.Cells {
+			for x, cell := range row {
+				for _, patch := range cell.Patches {
+					index, ok := indices[patch]
+					if !ok {
+						continue
+					}
+					saved.CellIndices = append(saved.CellIndices, y*cellsWidth+x)
+					saved.CellPatches = append(saved.CellPatches, index)
+				}
+			}
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(file)
+	if _, err = writer.WriteString(checkpointMagic); err == nil {
+		err = binary.Write(writer, binary.LittleEndian, checkpointVersion)
+	}
+	if err == nil {
+		err = gob.NewEncoder(writer).Encode(&state)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// LoadCheckpoint : Restores the state saved by SaveCheckpoint into the
+// images manager, which should hold the same photos. The reconstruction
+// resumes with newOptions, or with the saved options if it is nil. Nothing
+// is restored if the options are invalid
+func (imgsManager *ImagesManager) LoadCheckpoint(path string, newOptions *Options) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	magic := make([]byte, len(checkpointMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != checkpointMagic {
+		return errNotCheckpoint
+	}
+	var version uint32
+	if err = binary.Read(reader, binary.LittleEndian, &version); err != nil {
+		return
+	}
+	if version != checkpointVersion {
+		return errCheckpointVersion
+	}
+	var state checkpointState
+	if err = gob.NewDecoder(reader).Decode(&state); err != nil {
+		return
+	}
+	if newOptions == nil {
+		newOptions = state.Options
+	}
+	if newOptions == nil {
+		newOptions = NewOptions()
+	}
+	if err = newOptions.validate(); err != nil {
+		return
+	}
+
+	if len(state.Photos) != len(imgsManager.Photos) {
+		return errCheckpointMismatch
+	}
+	for i, photo := range imgsManager.Photos {
+		if state.Photos[i].Width != photo.Img.Width || state.Photos[i].Height != photo.Img.Height {
+			return errCheckpointMismatch
+		}
+	}
+	patches := make([]*Patch, len(state.Patches))
+	for i, saved := range state.Patches {
+		if saved.RefPhoto < 0 || saved.RefPhoto >= len(imgsManager.Photos) {
+			return errCheckpointMalformed
+		}
+		for _, id := range append(append([]int(nil), saved.VPhotos...), saved.TPhotos...) {
+			if id < 0 || id >= len(imgsManager.Photos) {
+				return errCheckpointMalformed
+			}
+		}
+		if !isFinite3(dehomogenize(mat.NewVecDense(4, saved.Center[:]))) {
+			return errCheckpointMalformed
+		}
+		patch := new(Patch)
+		patch.ID, patch.index = saved.ID, i
+		patch.Center = mat.NewVecDense(4, saved.Center[:])
+		patch.Normal = mat.NewVecDense(4, saved.Normal[:])
+		patch.RefPhoto, patch.VPhotos, patch.TPhotos = saved.RefPhoto, saved.VPhotos, saved.TPhotos
+		patch.Score, patch.Size, patch.Scale, patch.Status = saved.Score, saved.Size, saved.Scale, saved.Status
+		patches[i] = patch
+	}
+	cells := make([][][]*Cell, len(imgsManager.Photos))
+	for i, photo := range imgsManager.Photos {
+		saved := state.Photos[i]
+		if len(saved.CellIndices) != len(saved.CellPatches) {
+			return errCheckpointMalformed
+		}
+		cells[i] = newCells(photo.Img.Width, photo.Img.Height)
+		cellsWidth, numCells := len(cells[i][0]), len(cells[i])*len(cells[i][0])
+		for j, index := range saved.CellIndices {
+			patchIndex := saved.CellPatches[j]
+			if index < 0 || index >= numCells || patchIndex < 0 || patchIndex >= len(patches) {
+				return errCheckpointMalformed
+			}
+			cell := cells[i][index/cellsWidth][index%cellsWidth]
+			cell.Patches = append(cell.Patches, patches[patchIndex])
+			patches[patchIndex].cells = append(patches[patchIndex].cells, cell)
+		}
+	}
+
+	// the checkpoint is valid, nothing can fail from here on
+	for i, photo := range imgsManager.Photos {
+		photo.Feats, photo.Cells = state.Photos[i].Feats, cells[i]
+	}
+	imgsManager.Patches, imgsManager.removedPatches = patches, 0
+	imgsManager.nextPatchID = 0
+	imgsManager.PatchIndex = NewPatchIndex()
+	for _, patch := range patches {
+		imgsManager.PatchIndex.Insert(patch)
+		imgsManager.nextPatchID = maxInt(imgsManager.nextPatchID, patch.ID+1)
+	}
+	imgsManager.Phase, imgsManager.Iteration = state.Phase, state.Iteration
+	imgsManager.RejectedByROI = state.RejectedByROI
+	// the options are valid, they were checked above
+	SetOptions(newOptions)
+	return
+}
+
+// checkpoint : Saves a checkpoint when options.CheckpointPath is set. A
+// failure is reported but doesn't stop the reconstruction
+func checkpoint() {
+	if options.CheckpointPath == "" || imgsManager.isCluster {
+		return
+	}
+	if err := imgsManager.SaveCheckpoint(options.CheckpointPath); err != nil {
+		observer.Warning("checkpoint failed", "path", options.CheckpointPath, "error", err)
+	}
+}
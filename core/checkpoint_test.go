@@ -0,0 +1,209 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"pmvs/featdetect"
+	"reflect"
+	"testing"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// newCheckpointManager : Creates an images manager of three photos with
+// features, and registers patches when withPatches is set
+func newCheckpointManager(t *testing.T, withPatches bool) *ImagesManager {
+	t.Helper()
+	manager := newTestManager(testProjMat(1, 0), testProjMat(1, -1),
+		testProjMat(1, -2))
+	for i, photo := range manager.Photos {
+		photo.Feats = [][]*featdetect.Feature{
+			{featdetect.NewFeature(3+i, 4, 0.5, featdetect.DoG)},
+			{featdetect.NewFeature(10, 7+i, 2, featdetect.Harris)},
+		}
+	}
+	if !withPatches {
+		return manager
+	}
+	for i, center := range [][3]float64{{0.2, 0.1, 5}, {-0.3, 0.2, 6}, {0.4, -0.2, 4}} {
+		patch := newTestPatch(center[0], center[1], center[2])
+		if registered, reason := registerPatch(patch); !registered {
+			t.Fatalf("patch %v wasn't registered: %v", i, reason)
+		}
+		patch.Score, patch.Size = 0.9-0.1*float64(i), 0.01*float64(i+1)
+		patch.Scale, patch.Status = float64(i+1), optimize.FunctionConvergence
+	}
+	return manager
+}
+
+// cellPatchIDs : Returns the ids of the patches in every cell of the photos
+func cellPatchIDs(manager *ImagesManager) [][]int {
+	var ids [][]int
+	for _, photo := range manager.Photos {
+		for _, row := range photo.Cells {
+			for _, cell := range row {
+				var cellIDs []int
+				for _, patch := range cell.Patches {
+					cellIDs = append(cellIDs, patch.ID)
+				}
+				ids = append(ids, cellIDs)
+			}
+		}
+	}
+	return ids
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	defer SetOptions(options)
+	defer SetObserver(observer)
+	defer useImagesManager(imgsManager)
+	SetObserver(nil)
+	savedOptions := NewOptions()
+	savedOptions.NumNeighbours = 5
+	savedOptions.MaxScoreDrop = 0.2
+	savedOptions.ROI = &AxisAlignedBox{[3]float64{-1, -1, 0}, [3]float64{1, 1, 10}}
+	if err := SetOptions(savedOptions); err != nil {
+		t.Fatal(err)
+	}
+	saved := newCheckpointManager(t, true)
+	saved.Phase, saved.Iteration, saved.RejectedByROI = PhaseInitialMatching, 2, 3
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	if err := saved.SaveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+
+	SetOptions(NewOptions())
+	loaded := newCheckpointManager(t, false)
+	if err := loaded.LoadCheckpoint(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(options, savedOptions) {
+		t.Errorf("got options %+v, want %+v", options, savedOptions)
+	}
+	if loaded.Phase != saved.Phase || loaded.Iteration != saved.Iteration ||
+		loaded.RejectedByROI != saved.RejectedByROI {
+		t.Errorf("got phase %v, iteration %v and %v rejected, want %v, %v and %v",
+			loaded.Phase, loaded.Iteration, loaded.RejectedByROI,
+			saved.Phase, saved.Iteration, saved.RejectedByROI)
+	}
+	for i, photo := range loaded.Photos {
+		if !reflect.DeepEqual(photo.Feats, saved.Photos[i].Feats) {
+			t.Errorf("photo %v: got features %v, want %v", i, photo.Feats, saved.Photos[i].Feats)
+		}
+	}
+	if len(loaded.Patches) != len(saved.Patches) {
+		t.Fatalf("got %v patches, want %v", len(loaded.Patches), len(saved.Patches))
+	}
+	for i, patch := range loaded.Patches {
+		want := saved.Patches[i]
+		if patch.ID != want.ID || !mat.Equal(patch.Center, want.Center) ||
+			!mat.Equal(patch.Normal, want.Normal) || patch.RefPhoto != want.RefPhoto ||
+			!reflect.DeepEqual(patch.VPhotos, want.VPhotos) ||
+			!reflect.DeepEqual(patch.TPhotos, want.TPhotos) || patch.Score != want.Score ||
+			patch.Size != want.Size || patch.Scale != want.Scale || patch.Status != want.Status {
+			t.Errorf("got patch %+v, want %+v", patch, want)
+		}
+		if patch.index != i {
+			t.Errorf("patch %v is at %v but has index %v", patch.ID, i, patch.index)
+		}
+	}
+	if !reflect.DeepEqual(cellPatchIDs(loaded), cellPatchIDs(saved)) {
+		t.Errorf("got patches in cells %v, want %v", cellPatchIDs(loaded), cellPatchIDs(saved))
+	}
+	if loaded.PatchIndex.Len() != len(saved.Patches) || loaded.nextPatchID != saved.nextPatchID {
+		t.Errorf("got %v patches indexed and next id %v, want %v and %v",
+			loaded.PatchIndex.Len(), loaded.nextPatchID, len(saved.Patches), saved.nextPatchID)
+	}
+
+	// the photos must match
+	other := newTestManager(testProjMat(1, 0), testProjMat(1, -1))
+	if err := other.LoadCheckpoint(path, nil); err != errCheckpointMismatch {
+		t.Errorf("got error %v loading into other photos, want %v", err, errCheckpointMismatch)
+	}
+}
+
+// doneObserver : Records the photos done
+type doneObserver struct {
+	BaseObserver
+	photos []int
+}
+
+func (observer *doneObserver) PhotoDone(phase string, photoID, patches int,
+	elapsed time.Duration) {
+	observer.photos = append(observer.photos, photoID)
+}
+
+func TestCheckpointResume(t *testing.T) {
+	defer SetOptions(options)
+	defer SetObserver(observer)
+	defer useImagesManager(imgsManager)
+	SetOptions(NewOptions())
+	// the clusters are told apart by their number of photos
+	clusters := []*Cluster{{Photos: []int{0}}, {Photos: []int{0, 1}}, {Photos: []int{0, 1, 2}}}
+
+	tests := []struct {
+		phase        Phase
+		iteration    int
+		wantPhotos   []int
+		wantClusters []int
+	}{
+		{PhaseInitialMatching, 0, []int{0, 1, 2}, []int{1, 2, 3}},
+		{PhaseInitialMatching, 2, []int{2}, []int{1, 2, 3}},
+		{PhaseClusters, 1, nil, []int{2, 3}},
+		{PhaseClusters, 3, nil, nil},
+		{PhaseCompleted, 0, nil, nil},
+	}
+	for _, test := range tests {
+		saved := newCheckpointManager(t, true)
+		saved.Phase, saved.Iteration = test.phase, test.iteration
+		path := filepath.Join(t.TempDir(), "checkpoint")
+		if err := saved.SaveCheckpoint(path); err != nil {
+			t.Fatal(err)
+		}
+		load := func() *ImagesManager {
+			loaded := newCheckpointManager(t, false)
+			if err := loaded.LoadCheckpoint(path, nil); err != nil {
+				t.Fatal(err)
+			}
+			return loaded
+		}
+
+		// the initial matching resumes at the saved photo, and is skipped
+		// once it is done
+		loaded := load()
+		recorder := new(doneObserver)
+		SetObserver(recorder)
+		if err := StartMatching(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		wantPhase := test.phase
+		if wantPhase == PhaseInitialMatching {
+			wantPhase = PhaseCompleted
+		}
+		if !reflect.DeepEqual(recorder.photos, test.wantPhotos) || loaded.Phase != wantPhase {
+			t.Errorf("phase %v, iteration %v: matched photos %v and ended in phase %v, "+
+				"want %v and %v", test.phase, test.iteration, recorder.photos, loaded.Phase,
+				test.wantPhotos, wantPhase)
+		}
+
+		// the clusters resume at the saved cluster
+		loaded = load()
+		SetObserver(nil)
+		var reconstructed []int
+		err := loaded.ReconstructClusters(context.Background(), clusters,
+			func(ctx context.Context) error {
+				reconstructed = append(reconstructed, len(imgsManager.Photos))
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reconstructed, test.wantClusters) || loaded.Phase != PhaseCompleted {
+			t.Errorf("phase %v, iteration %v: reconstructed clusters %v and ended in phase %v, "+
+				"want %v and %v", test.phase, test.iteration, reconstructed, loaded.Phase,
+				test.wantClusters, PhaseCompleted)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package core
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// photos a sparse point needs in a cluster to be reconstructed there
+	minClusterViews = 3
+	// neighbours a photo shares a cluster with when there are no sparse
+	// points
+	clusterNeighbours = 2
+)
+
+// Cluster : A subset of the photos that is reconstructed on its own
+type Cluster struct {
+	Photos []int
+}
+
+// ClusterPhotos : Splits the photos into overlapping clusters of at most
+// maxSize photos, in the spirit of CMVS. Photos are first partitioned by
+// growing clusters along the view graph, whose edges count the sparse points
+// two photos share, then photos are added to the clusters until every
+// sparse point is seen by minClusterViews photos of one cluster, as long as
+// the clusters have room. A quarter of each cluster is kept for the overlap.
+// Without sparse points every photo is covered along with its best
+// neighbours instead
+func (imgsManager *ImagesManager) ClusterPhotos(maxSize int) []*Cluster {
+	if maxSize < minClusterViews {
+		panic("Clusters should hold at least 3 photos")
+	}
+	numPhotos := len(imgsManager.Photos)
+	if numPhotos <= maxSize {
+		cluster := new(Cluster)
+		for i := 0; i < numPhotos; i++ {
+			cluster.Photos = append(cluster.Photos, i)
+		}
+		return []*Cluster{cluster}
+	}
+
+	visibility := imgsManager.visibilitySets()
+	graph := viewGraph(numPhotos, visibility)
+	clusters := partitionViews(graph, maxSize-maxSize/4)
+	coverVisibility(clusters, visibility, maxSize)
+	for _, cluster := range clusters {
+		sort.Ints(cluster.Photos)
+	}
+	return clusters
+}
+
+// visibilitySets : Returns the sets of photos that should be reconstructed
+// together, the photos of every sparse point or, without sparse points,
+// every photo along with its best neighbours
+func (imgsManager *ImagesManager) visibilitySets() [][]int {
+	var sets [][]int
+	for _, point := range imgsManager.SparsePoints {
+		if len(point.Photos) >= minClusterViews {
+			sets = append(sets, point.Photos)
+		}
+	}
+	if len(sets) != 0 {
+		return sets
+	}
+	for id := range imgsManager.Photos {
+		neighbours := getRelevantImages(id)
+		if len(neighbours) > clusterNeighbours {
+			neighbours = neighbours[:clusterNeighbours]
+		}
+		sets = append(sets, append([]int{id}, neighbours...))
+	}
+	return sets
+}
+
+// viewGraph : Weights every pair of photos by the number of visibility sets
+// containing both
+func viewGraph(numPhotos int, visibility [][]int) []map[int]float64 {
+	graph := make([]map[int]float64, numPhotos)
+	for i := range graph {
+		graph[i] = make(map[int]float64)
+	}
+	for _, photos := range visibility {
+		for i, id1 := range photos {
+			for _, id2 := range photos[i+1:] {
+				if id1 != id2 {
+					graph[id1][id2]++
+					graph[id2][id1]++
+				}
+			}
+		}
+	}
+	return graph
+}
+
+// partitionViews : Partitions the photos into clusters of at most size
+// photos. A cluster is seeded with the most connected photo left, then grows
+// by the photo most connected to it
+func partitionViews(graph []map[int]float64, size int) []*Cluster {
+	numPhotos := len(graph)
+	assigned := make([]bool, numPhotos)
+	degrees := make([]float64, numPhotos)
+	for i, edges := range graph {
+		for _, weight := range edges {
+			degrees[i] += weight
+		}
+	}
+
+	var clusters []*Cluster
+	for {
+		seed := bestView(degrees, assigned)
+		if seed < 0 {
+			break
+		}
+		cluster := new(Cluster)
+		links := make([]float64, numPhotos)
+		for id := seed; id >= 0 && len(cluster.Photos) < size; id = bestView(links, assigned) {
+			if id != seed && links[id] == 0 {
+				break
+			}
+			assigned[id] = true
+			cluster.Photos = append(cluster.Photos, id)
+			for id2, weight := range graph[id] {
+				links[id2] += weight
+			}
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// bestView : Returns the unassigned photo with the highest score, or -1
+func bestView(scores []float64, assigned []bool) int {
+	best := -1
+	for i, score := range scores {
+		if !assigned[i] && (best < 0 || score > scores[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// coverVisibility : Adds photos to the clusters until every visibility set
+// has minClusterViews photos in one cluster, without exceeding maxSize. A
+// set is covered by the cluster that already holds most of its photos
+func coverVisibility(clusters []*Cluster, visibility [][]int, maxSize int) {
+	members := make([]map[int]bool, len(clusters))
+	for i, cluster := range clusters {
+		members[i] = make(map[int]bool)
+		for _, id := range cluster.Photos {
+			members[i][id] = true
+		}
+	}
+	uncovered := 0
+	for _, photos := range visibility {
+		needed := minInt(minClusterViews, len(photos))
+		best, bestCount := -1, 0
+		for i := range clusters {
+			count := 0
+			for _, id := range photos {
+				if members[i][id] {
+					count++
+				}
+			}
+			if count > bestCount {
+				best, bestCount = i, count
+			}
+		}
+		if best < 0 || bestCount >= needed {
+			continue
+		}
+		for _, id := range photos {
+			if bestCount >= needed || len(clusters[best].Photos) >= maxSize {
+				break
+			}
+			if !members[best][id] {
+				members[best][id] = true
+				clusters[best].Photos = append(clusters[best].Photos, id)
+				bestCount++
+			}
+		}
+		if bestCount < needed {
+			uncovered++
+		}
+	}
+	if uncovered != 0 {
+		observer.Warning("clusters don't cover every view set",
+			"uncovered", uncovered, "total", len(visibility))
+	}
+}
+
+// ReconstructClusters : Runs reconstruct on every cluster in turn, then
+// merges the patches of all the clusters into the images manager. While
+// reconstruct runs, the package works on a manager holding only the photos
+// of the cluster, so fundamental matrices and neighbours are only computed
+// within the cluster. Patches found by several clusters are kept once.
+// The patches of every cluster are merged once it is done and a checkpoint
+// is saved, a reconstruction restored from a checkpoint resumes at the
+// cluster it stopped, so clusters should be the same. If reconstruct fails,
+// for instance because ctx is cancelled, the patches it created are merged
+// and its error is returned
+func (imgsManager *ImagesManager) ReconstructClusters(
+	ctx context.Context,
+	clusters []*Cluster,
+	reconstruct func(ctx context.Context) error,
+) (err error) {
+	const phase = "clusters"
+	if imgsManager.Phase == PhaseCompleted {
+		observer.Warning("clusters are already reconstructed")
+		return nil
+	}
+	first := 0
+	if imgsManager.Phase == PhaseClusters {
+		first = imgsManager.Iteration
+	}
+	observer.PhaseStarted(phase, len(clusters))
+	start, total := time.Now(), 0
+	for i := first; i < len(clusters); i++ {
+		cluster := clusters[i]
+		clusterManager := imgsManager.subManager(cluster)
+		err = runWithImagesManager(ctx, clusterManager, reconstruct)
+		imgsManager.RejectedByROI += clusterManager.RejectedByROI
+		if clusterManager.Report != nil {
+			if imgsManager.Report == nil {
+				imgsManager.Report = newMatchingReport(len(imgsManager.Photos))
+			}
+			imgsManager.Report.merge(clusterManager.Report, cluster.Photos)
+		}
+
+		clusterManager.CompactPatches()
+		for _, patch := range clusterManager.Patches {
+			patch.RefPhoto = cluster.Photos[patch.RefPhoto]
+			for j, id := range patch.VPhotos {
+				patch.VPhotos[j] = cluster.Photos[id]
+			}
+			for j, id := range patch.TPhotos {
+				patch.TPhotos[j] = cluster.Photos[id]
+			}
+		}
+		total += imgsManager.mergePatches(clusterManager.Patches)
+		if err != nil {
+			// the cluster is reconstructed again when resuming
+			checkpoint()
+			observer.PhaseEnded(phase, total, time.Since(start))
+			return
+		}
+		imgsManager.Phase, imgsManager.Iteration = PhaseClusters, i+1
+		checkpoint()
+		observer.Progress(phase, i+1, len(clusters))
+	}
+	imgsManager.Phase, imgsManager.Iteration = PhaseCompleted, 0
+	checkpoint()
+	observer.PhaseEnded(phase, total, time.Since(start))
+	return
+}
+
+// useImagesManager : Makes manager the one used by the package
+func useImagesManager(manager *ImagesManager) {
+	imgsManager = manager
+}
+
+// runWithImagesManager : Runs reconstruct while manager is the one used by
+// the package, the previous manager is used again afterwards even if
+// reconstruct panics
+func runWithImagesManager(ctx context.Context, manager *ImagesManager,
+	reconstruct func(ctx context.Context) error) error {
+
+	previous := imgsManager
+	useImagesManager(manager)
+	defer useImagesManager(previous)
+	return reconstruct(ctx)
+}
+
+// subManager : Creates a manager holding the photos of the cluster, with
+// ids local to the cluster and empty cells. Images, the images derived from
+// them, features and cameras are shared with the original photos
+func (imgsManager *ImagesManager) subManager(cluster *Cluster) *ImagesManager {
+	localIDs := make(map[int]int, len(cluster.Photos))
+	manager := new(ImagesManager)
+	manager.FundMats = make(map[[2]int]*mat.Dense)
+	manager.PatchIndex = NewPatchIndex()
+	manager.Hull = imgsManager.Hull
+	manager.isCluster = true
+	for i, id := range cluster.Photos {
+		original := imgsManager.Photos[id]
+		photo := new(Photo)
+		photo.Img, photo.Mask, photo.derived = original.Img, original.Mask, original.derived
+		photo.Cam, photo.Feats = original.Cam, original.Feats
+		photo.Cells = newCells(photo.Img.Width, photo.Img.Height)
+		photo.ID = i
+		manager.Photos = append(manager.Photos, photo)
+		localIDs[id] = i
+	}
+	for _, point := range imgsManager.SparsePoints {
+		var photos []int
+		for _, id := range point.Photos {
+			if localID, ok := localIDs[id]; ok {
+				photos = append(photos, localID)
+			}
+		}
+		if len(photos) != 0 {
+			localPoint := new(SparsePoint)
+			localPoint.Position, localPoint.Photos = point.Position, photos
+			manager.SparsePoints = append(manager.SparsePoints, localPoint)
+		}
+	}
+	return manager
+}
+
+// mergePatches : Registers the patches, the ones seen by more photos first,
+// and drops those duplicating a registered patch. Returns the number of
+// patches registered
+func (imgsManager *ImagesManager) mergePatches(patches []*Patch) int {
+	sort.SliceStable(patches, func(i, j int) bool {
+		return len(patches[i].TPhotos) > len(patches[j].TPhotos)
+	})
+	num := 0
+	for _, patch := range patches {
+		if isDuplicatePatch(patch) {
+			continue
+		}
+		if registered, _ := registerPatch(patch); registered {
+			num++
+		}
+	}
+	return num
+}
+
+// isDuplicatePatch : Checks whether a registered patch shares a cell with
+// the patch in one of its photos, lies within a cell of its plane and faces
+// the same way
+func isDuplicatePatch(patch *Patch) bool {
+	diff := mat.NewVecDense(4, nil)
+	for _, photoID := range patch.TPhotos {
+		photo := imgsManager.Photos[photoID]
+		cell := photo.projectToCell(patch.Center)
+		if cell == nil {
+			continue
+		}
+		maxDist := pixelSize(photo, patch.Center) * cellSize
+		for _, other := range cell.Patches {
+			diff.SubVec(other.Center, patch.Center)
+			if math.Abs(mat.Dot(diff, patch.Normal)) <= maxDist &&
+				mat.Dot(other.Normal, patch.Normal) >= cosMaxAngle {
+				return true
+			}
+		}
+	}
+	return false
+}
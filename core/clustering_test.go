@@ -0,0 +1,118 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// setSphereSparsePoints : Sets num sparse points spread over the band of
+// the unit sphere between z = -0.5 and 0.5, seen by the photos they face and
+// project in
+func setSphereSparsePoints(manager *ImagesManager, num int) {
+	positions := make([][]float64, num)
+	visibility := make([][]int, num)
+	projected := mat.NewVecDense(3, nil)
+	for i := range positions {
+		// Fibonacci sphere
+		z := 0.5 - (float64(i)+0.5)/float64(num)
+		radius := math.Sqrt(1 - z*z)
+		angle := float64(i) * math.Pi * (3 - math.Sqrt(5))
+		positions[i] = []float64{radius * math.Cos(angle), radius * math.Sin(angle), z}
+		point := mat.NewVecDense(4, []float64{positions[i][0], positions[i][1], z, 1})
+		for _, photo := range manager.Photos {
+			center := photo.OpticalCenter()
+			facing := (center.AtVec(0)-point.AtVec(0))*point.AtVec(0) +
+				(center.AtVec(1)-point.AtVec(1))*point.AtVec(1) +
+				(center.AtVec(2)-point.AtVec(2))*point.AtVec(2)
+			projected.MulVec(photo.CameraMatrix(), point)
+			if facing > 0 && photo.Cam.InFront(point) &&
+				photo.Contains(projected.AtVec(1)/projected.AtVec(2),
+					projected.AtVec(0)/projected.AtVec(2)) {
+				visibility[i] = append(visibility[i], photo.ID)
+			}
+		}
+	}
+	manager.SetSparsePoints(positions, visibility)
+}
+
+func TestClusterPhotos(t *testing.T) {
+	defer SetObserver(observer)
+	defer useImagesManager(imgsManager)
+	manager := newSphereManager(sphereCameras(12, 4))
+	setSphereSparsePoints(manager, 200)
+	recorder := new(phaseObserver)
+	SetObserver(recorder)
+
+	maxSize := 5
+	clusters := manager.ClusterPhotos(maxSize)
+	if len(clusters) < 3 {
+		t.Fatalf("got %v clusters, want at least 3", len(clusters))
+	}
+	clustered := make(map[int]bool)
+	for i, cluster := range clusters {
+		if len(cluster.Photos) == 0 || len(cluster.Photos) > maxSize {
+			t.Errorf("cluster %v has %v photos, want 1 to %v", i, len(cluster.Photos), maxSize)
+		}
+		if !sort.IntsAreSorted(cluster.Photos) {
+			t.Errorf("cluster %v isn't sorted: %v", i, cluster.Photos)
+		}
+		for j, id := range cluster.Photos {
+			if j > 0 && cluster.Photos[j-1] == id {
+				t.Errorf("cluster %v holds photo %v twice", i, id)
+			}
+			clustered[id] = true
+		}
+	}
+	for id := range manager.Photos {
+		if !clustered[id] {
+			t.Errorf("photo %v is in no cluster", id)
+		}
+	}
+
+	// the clusters only have room for a few more photos, most sparse points
+	// are seen by minClusterViews photos of a cluster and the others are
+	// reported
+	uncovered := 0
+	for _, point := range manager.SparsePoints {
+		if len(point.Photos) < minClusterViews {
+			continue
+		}
+		covered := false
+		for _, cluster := range clusters {
+			count := 0
+			for _, id := range point.Photos {
+				if j := sort.SearchInts(cluster.Photos, id); j < len(cluster.Photos) &&
+					cluster.Photos[j] == id {
+					count++
+				}
+			}
+			covered = covered || count >= minClusterViews
+		}
+		if !covered {
+			uncovered++
+		}
+	}
+	if uncovered > len(manager.SparsePoints)/20 {
+		t.Errorf("%v sparse points out of %v aren't covered", uncovered,
+			len(manager.SparsePoints))
+	}
+	if wantWarnings := minInt(uncovered, 1); recorder.warnings != wantWarnings {
+		t.Errorf("got %v warnings with %v sparse points uncovered, want %v",
+			recorder.warnings, uncovered, wantWarnings)
+	}
+
+	// cluster photos share the images derived from the original photos
+	original := manager.Photos[clusters[0].Photos[0]]
+	luminance := original.Luminance()
+	clusterManager := manager.subManager(clusters[0])
+	photo := clusterManager.Photos[0]
+	if photo.Luminance() != luminance {
+		t.Error("the cluster photo computed its luminance again")
+	}
+	if photo.Integral() != original.Integral() {
+		t.Error("the cluster photo doesn't share the integral of the original photo")
+	}
+}
@@ -45,26 +45,19 @@ func getRelevantFeatures(
 	return
 }
 
-// getRelevantImages : Find images that look at the same parts
+// getRelevantImages : Find images that look at the same parts, the result
+// is computed once per photo by the view selection
 func getRelevantImages(id int) []int {
-	img := imgsManager.Photos[id]
-	opticalAxis1 := img.OpticalAxis()
-	relevantImgs := make([]int, 0, 5)
-	for i := 0; i < len(imgsManager.Photos); i++ {
-		if i == id {
-			continue
-		}
-		img2 := imgsManager.Photos[i]
-		opticalAxis2 := img2.OpticalAxis()
-		cosAngle := mat.Dot(opticalAxis1, opticalAxis2)
-		if cosAngle > cosMaxAngle && cosAngle < cosMinAngle {
-			relevantImgs = append(relevantImgs, i)
-		}
+	photo := imgsManager.Photos[id]
+	if photo.neighbours == nil {
+		photo.neighbours = selectViews(id)
 	}
-	return relevantImgs
+	return photo.neighbours
 }
 
-func getPatchVectors(photo *Photo, center, normal *mat.VecDense) (right, up *mat.VecDense) {
+// getPatchVectors : Returns the vectors on the plane of the patch between
+// neighbouring samples of its grid, scale pixels long in photo
+func getPatchVectors(photo *Photo, center, normal *mat.VecDense, scale float64) (right, up *mat.VecDense) {
 	proj := photo.Cam.ProjMat
 	// right and up vectors are the first and second column of the
 	// pseudo inverse of the projection matrix
@@ -72,31 +65,31 @@ func getPatchVectors(photo *Photo, center, normal *mat.VecDense) (right, up *mat
 
 	// subtract the normal component from right vector
 	// now right lies on the plane defined by normal
-	scale := pinv.At(0, 0)*normal.AtVec(0) +
+	normalScale := pinv.At(0, 0)*normal.AtVec(0) +
 		pinv.At(1, 0)*normal.AtVec(1) +
 		pinv.At(2, 0)*normal.AtVec(2)
 	right = mat.NewVecDense(4, []float64{
-		pinv.At(0, 0) - scale*normal.AtVec(0),
-		pinv.At(1, 0) - scale*normal.AtVec(1),
-		pinv.At(2, 0) - scale*normal.AtVec(2),
+		pinv.At(0, 0) - normalScale*normal.AtVec(0),
+		pinv.At(1, 0) - normalScale*normal.AtVec(1),
+		pinv.At(2, 0) - normalScale*normal.AtVec(2),
 		0,
 	})
 
 	// subtract the normal component from up vector
 	// now up lies on the plane defined by normal
-	scale = pinv.At(0, 1)*normal.AtVec(0) +
+	normalScale = pinv.At(0, 1)*normal.AtVec(0) +
 		pinv.At(1, 1)*normal.AtVec(1) +
 		pinv.At(2, 1)*normal.AtVec(2)
 	up = mat.NewVecDense(4, []float64{
-		pinv.At(0, 1) - scale*normal.AtVec(0),
-		pinv.At(1, 1) - scale*normal.AtVec(1),
-		pinv.At(2, 1) - scale*normal.AtVec(2),
+		pinv.At(0, 1) - normalScale*normal.AtVec(0),
+		pinv.At(1, 1) - normalScale*normal.AtVec(1),
+		pinv.At(2, 1) - normalScale*normal.AtVec(2),
 		0,
 	})
 
-	scale = mat.Dot(center, proj.RowView(2))
-	right.ScaleVec(scale/mat.Dot(proj.RowView(0), right), right)
-	up.ScaleVec(scale/mat.Dot(proj.RowView(1), up), up)
+	depth := scale * mat.Dot(center, proj.RowView(2))
+	right.ScaleVec(depth/mat.Dot(proj.RowView(0), right), right)
+	up.ScaleVec(depth/mat.Dot(proj.RowView(1), up), up)
 	return
 }
 
@@ -180,12 +173,13 @@ func projectGrid(photo *Photo, center, right, up *mat.VecDense,
 
 func patchNCCScore(photo *Photo, patch *Patch, right, up *mat.VecDense) float64 {
 	refPhoto := imgsManager.Photos[patch.RefPhoto]
-	cell1 := make([]float32, patchGridSize*patchGridSize*3)
-	cell2 := make([]float32, patchGridSize*patchGridSize*3)
+	gridSize := options.WindowSize
+	cell1 := make([]float32, gridSize*gridSize*3)
+	cell2 := make([]float32, gridSize*gridSize*3)
 
-	cell1 = projectGrid(refPhoto, patch.Center, right, up, patchGridSize, cell1)
-	cell2 = projectGrid(photo, patch.Center, right, up, patchGridSize, cell2)
-	return ncc(cell1, cell2)
+	cell1 = projectGrid(refPhoto, patch.Center, right, up, gridSize, cell1)
+	cell2 = projectGrid(photo, patch.Center, right, up, gridSize, cell2)
+	return photoScore(cell1, cell2)
 }
 
 func ncc(cell1 []float32, cell2 []float32) float64 {
@@ -214,16 +208,22 @@ func ncc(cell1 []float32, cell2 []float32) float64 {
 }
 
 func visualHullCheck(point *mat.VecDense) bool {
+	if imgsManager.Hull != nil {
+		return imgsManager.Hull.Contains(point)
+	}
+	return masksCheck(point)
+}
+
+// masksCheck : Checks that the point isn't masked in any photo it is in
+// front of
+func masksCheck(point *mat.VecDense) bool {
 	projectedPoint := mat.NewVecDense(3, nil)
 	for _, photo := range imgsManager.Photos {
-		if photo.Mask == nil {
+		if photo.Mask == nil || !photo.Cam.InFront(point) {
 			continue
 		}
 		projectedPoint.MulVec(photo.CameraMatrix(), point)
 		scale := projectedPoint.AtVec(2)
-		if scale == 0 {
-			continue
-		}
 		if photo.IsMasked(projectedPoint.AtVec(1)/scale,
 			projectedPoint.AtVec(0)/scale) {
 			return false
@@ -232,41 +232,257 @@ func visualHullCheck(point *mat.VecDense) bool {
 	return true
 }
 
-func constraintPhotos(patch *Patch, minNCC float64, searchIDs []int) []int {
+// roiCheck : Checks that the point lies inside the region of interest
+func roiCheck(point *mat.VecDense) bool {
+	return options.ROI == nil || options.ROI.Contains(point)
+}
+
+// TextureMeasure : How the texture of a window of the luminance of a photo
+// is measured
+type TextureMeasure int
+
+const (
+	// TextureVariance : Variance of the luminance
+	TextureVariance TextureMeasure = iota
+	// TextureGradientEnergy : Mean squared norm of the gradient of the
+	// luminance
+	TextureGradientEnergy
+)
+
+// textureCheck : Checks that the window the patch is sampled on in its
+// reference photo, around the projection of its center, has at least
+// MinTexture
+func textureCheck(patch *Patch) bool {
+	if options.MinTexture <= 0 {
+		return true
+	}
+	refPhoto := imgsManager.Photos[patch.RefPhoto]
+	projected := mat.NewVecDense(3, nil)
+	projected.MulVec(refPhoto.CameraMatrix(), patch.Center)
+	x := int(projected.AtVec(0)/projected.AtVec(2) + 0.5)
+	y := int(projected.AtVec(1)/projected.AtVec(2) + 0.5)
+	radius := int(patch.Scale*float64(options.WindowSize-1)/2 + 0.5)
+
+	if options.TextureMeasure == TextureGradientEnergy {
+		gradX, gradY := refPhoto.Gradients()
+		var energy float64
+		count := 0
+		for j := maxInt(y-radius, 0); j <= minInt(y+radius, gradX.Height-1); j++ {
+			for i := maxInt(x-radius, 0); i <= minInt(x+radius, gradX.Width-1); i++ {
+				dx, dy := float64(gradX.At(j, i, 0)), float64(gradY.At(j, i, 0))
+				energy += dx*dx + dy*dy
+				count++
+			}
+		}
+		return count != 0 && energy/float64(count) >= options.MinTexture
+	}
+	return refPhoto.Integral().Variance(y-radius, x-radius, y+radius, x+radius) >=
+		options.MinTexture
+}
+
+// constraintPhotos : Returns the photos the patch is visible in, as
+// visiblePhotos, whose NCC score with the reference photo is at least
+// minNCC, so that TPhotos is always a subset of VPhotos. If MaxScoreDrop is
+// set, photos scoring less than the aggregation of the scores minus
+// MaxScoreDrop are dropped too. The scores are counted in scores unless it
+// is nil
+func constraintPhotos(patch *Patch, minNCC float64, searchIDs []int, scores *Histogram) []int {
 	refPhoto := imgsManager.Photos[patch.RefPhoto]
-	right, up := getPatchVectors(refPhoto, patch.Center, patch.Normal)
+	right, up := getPatchVectors(refPhoto, patch.Center, patch.Normal, patch.Scale)
+	visible := visiblePhotos(patch, searchIDs)
+	nccScores := make([]float64, len(visible))
+	for i, photoID := range visible {
+		photo := imgsManager.Photos[photoID]
+		nccScores[i] = patchNCCScore(photo, patch, right, up)
+		if scores != nil {
+			scores.Add(nccScores[i])
+		}
+	}
+	if options.MaxScoreDrop > 0 && len(nccScores) != 0 {
+		// aggregateScores reorders the scores
+		aggregated := aggregateScores(append([]float64(nil), nccScores...))
+		minNCC = math.Max(minNCC, aggregated-options.MaxScoreDrop)
+	}
+
 	result := make([]int, 0, 5)
-	depthVector := mat.NewVecDense(4, nil)
+	for i, photoID := range visible {
+		if nccScores[i] >= minNCC {
+			result = append(result, photoID)
+		}
+	}
+	return result
+}
 
+// visiblePhotos : Returns the photos facing the patch whose image holds the
+// patch center outside of the mask
+func visiblePhotos(patch *Patch, searchIDs []int) []int {
+	result := make([]int, 0, len(searchIDs))
+	depthVector := mat.NewVecDense(4, nil)
+	projected := mat.NewVecDense(3, nil)
 	for _, photoID := range searchIDs {
 		photo := imgsManager.Photos[photoID]
 		depthVector.SubVec(photo.OpticalCenter(), patch.Center)
 		if mat.Dot(depthVector, patch.Normal) <= 0 {
 			continue
 		}
-		nccScore := patchNCCScore(photo, patch, right, up)
-		if nccScore >= minNCC {
-			result = append(result, photoID)
+		if !photo.Cam.InFront(patch.Center) {
+			continue
+		}
+		projected.MulVec(photo.CameraMatrix(), patch.Center)
+		x := projected.AtVec(0) / projected.AtVec(2)
+		y := projected.AtVec(1) / projected.AtVec(2)
+		if !photo.Contains(y, x) ||
+			(photo.Mask != nil && photo.IsMasked(y, x)) {
+			continue
 		}
+		result = append(result, photoID)
 	}
 	return result
 }
 
-func registerPatch(patch *Patch) {
-	photoCoord := mat.NewVecDense(3, nil)
+// patchScore : Returns the aggregated photo measure between the reference
+// photo and the target photos of the patch, and the side of the patch in
+// world units
+func patchScore(patch *Patch) (score, size float64) {
+	refPhoto := imgsManager.Photos[patch.RefPhoto]
+	right, up := getPatchVectors(refPhoto, patch.Center, patch.Normal, patch.Scale)
+	if len(patch.TPhotos) != 0 {
+		score = nccObjective(patch.Center, right, up, refPhoto, patch.TPhotos)
+	}
+	size = float64(options.WindowSize-1) * (mat.Norm(right, 2) + mat.Norm(up, 2)) / 2
+	return
+}
+
+// adaptPatchScale : Sets the scale of the patch to one pixel per sample,
+// unless the adaptive scale is enabled, in which case the scale doubles
+// until the variance of the grid in the reference photo reaches
+// MinPatchVariance or the scale reaches MaxPatchScale
+func adaptPatchScale(patch *Patch) {
+	patch.Scale = 1
+	if !options.AdaptiveScale {
+		return
+	}
+	refPhoto := imgsManager.Photos[patch.RefPhoto]
+	cell := make([]float32, options.WindowSize*options.WindowSize*3)
+	for patch.Scale < options.MaxPatchScale {
+		right, up := getPatchVectors(refPhoto, patch.Center, patch.Normal, patch.Scale)
+		cell = projectGrid(refPhoto, patch.Center, right, up, options.WindowSize, cell)
+		if variance(cell) >= options.MinPatchVariance {
+			return
+		}
+		patch.Scale = math.Min(2*patch.Scale, options.MaxPatchScale)
+	}
+}
+
+func variance(cell []float32) float64 {
+	var mean, sqMean float64
+	for _, val := range cell {
+		mean += float64(val)
+		sqMean += float64(val) * float64(val)
+	}
+	mean /= float64(len(cell))
+	sqMean /= float64(len(cell))
+	return sqMean - mean*mean
+}
+
+// registerPatch : Adds the patch to the cells it projects to, to the list
+// of patches and to the index, and gives it the next id. Photos the patch
+// is behind or projects outside of are dropped from TPhotos. Patches outside
+// the region of interest, whose center isn't finite or left with less than
+// 2 TPhotos are rejected, the reason is returned
+func registerPatch(patch *Patch) (registered bool, reason RejectReason) {
+	imgsManager.patchesMutex.Lock()
+	defer imgsManager.patchesMutex.Unlock()
+	if !roiCheck(patch.Center) {
+		imgsManager.RejectedByROI++
+		observer.PatchRejected(RejectROI)
+		return false, RejectROI
+	}
+	if !isFinite3(dehomogenize(patch.Center)) {
+		observer.PatchRejected(RejectNonFinite)
+		return false, RejectNonFinite
+	}
+	cells := patch.cells[:0]
+	tPhotos := patch.TPhotos[:0]
 	for _, photoID := range patch.TPhotos {
-		photo := imgsManager.Photos[photoID]
-		photoCoord.MulVec(photo.CameraMatrix(), patch.Center)
-		x := int(photoCoord.AtVec(0)/photoCoord.AtVec(2)) / cellSize
-		y := int(photoCoord.AtVec(1)/photoCoord.AtVec(2)) / cellSize
-		cell := photo.Cells[y][x]
+		cell := imgsManager.Photos[photoID].projectToCell(patch.Center)
+		if cell == nil {
+			continue
+		}
+		cells = append(cells, cell)
+		tPhotos = append(tPhotos, photoID)
+	}
+	patch.cells, patch.TPhotos = cells, tPhotos
+	if len(patch.TPhotos) < 2 {
+		observer.PatchRejected(RejectRefinedPhotos)
+		return false, RejectRefinedPhotos
+	}
+	for _, cell := range patch.cells {
 		cell.Patches = append(cell.Patches, patch)
 	}
+	imgsManager.PatchIndex.Insert(patch)
+	patch.ID, patch.index = imgsManager.nextPatchID, len(imgsManager.Patches)
+	imgsManager.nextPatchID++
 	imgsManager.Patches = append(imgsManager.Patches, patch)
+	observer.PatchCreated(patch)
+	return true, ""
+}
+
+// unregisterPatch : Removes the patch from the images manager in use
+func unregisterPatch(patch *Patch) bool {
+	return imgsManager.RemovePatch(patch)
+}
+
+// RemovePatch : Removes the patch from its cells, the index and the list of
+// patches, where it leaves a nil entry until the list is compacted. Takes
+// time proportional to the patches sharing its cells. Returns whether the
+// patch was registered
+func (imgsManager *ImagesManager) RemovePatch(patch *Patch) bool {
+	imgsManager.patchesMutex.Lock()
+	defer imgsManager.patchesMutex.Unlock()
+	if patch.index < 0 || patch.index >= len(imgsManager.Patches) ||
+		imgsManager.Patches[patch.index] != patch {
+		return false
+	}
+	for _, cell := range patch.cells {
+		for i, other := range cell.Patches {
+			if other == patch {
+				last := len(cell.Patches) - 1
+				cell.Patches[i] = cell.Patches[last]
+				cell.Patches[last] = nil
+				cell.Patches = cell.Patches[:last]
+				break
+			}
+		}
+	}
+	patch.cells = nil
+	imgsManager.Patches[patch.index] = nil
+	patch.index = -1
+	imgsManager.removedPatches++
+	imgsManager.PatchIndex.Remove(patch)
+	return true
+}
+
+// CompactPatches : Drops the nil entries removed patches left in the list
+// of patches, the order of the remaining patches and their ids are kept
+func (imgsManager *ImagesManager) CompactPatches() {
+	imgsManager.patchesMutex.Lock()
+	defer imgsManager.patchesMutex.Unlock()
+	if imgsManager.removedPatches == 0 {
+		return
+	}
+	patches := make([]*Patch, 0, len(imgsManager.Patches)-imgsManager.removedPatches)
+	for _, patch := range imgsManager.Patches {
+		if patch != nil {
+			patch.index = len(patches)
+			patches = append(patches, patch)
+		}
+	}
+	imgsManager.Patches, imgsManager.removedPatches = patches, 0
 }
 
+// getCell : Returns the cell holding pixel (y, x) of the photo, or nil if
+// the pixel is outside the image
 func getCell(photoID, y, x int) *Cell {
-	cellY := y / cellSize
-	cellX := x / cellSize
-	return imgsManager.Photos[photoID].Cells[cellY][cellX]
+	return imgsManager.Photos[photoID].cellAt(y, x)
 }
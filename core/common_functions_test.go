@@ -0,0 +1,197 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"pmvs/image"
+	"sync"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	testWidth  = 40
+	testHeight = 30
+)
+
+// newTestManager : Creates an images manager of blank photos with the given
+// projection matrices
+func newTestManager(projMats ...[]float64) *ImagesManager {
+	imgs := make([]*image.CHWImage, len(projMats))
+	masks := make([]*image.CHWImage, len(projMats))
+	for i := range projMats {
+		imgs[i] = image.NewImage(testHeight, testWidth, 3)
+		masks[i] = image.NewImage(testHeight, testWidth, 1)
+	}
+	return NewImagesManager(imgs, masks, projMats)
+}
+
+// testProjMat : Returns scale * K [I | (tx, 0, 0)], the camera at (-tx, 0, 0)
+// looking along z
+func testProjMat(scale, tx float64) []float64 {
+	focal, cx, cy := 20.0, testWidth/2.0, testHeight/2.0
+	projMat := []float64{
+		focal, 0, cx, focal * tx,
+		0, focal, cy, 0,
+		0, 0, 1, 0,
+	}
+	for i := range projMat {
+		projMat[i] *= scale
+	}
+	return projMat
+}
+
+// newTestPatch : Creates a patch at (x, y, z) seen by the first three
+// photos
+func newTestPatch(x, y, z float64) *Patch {
+	patch := new(Patch)
+	patch.Center = mat.NewVecDense(4, []float64{x, y, z, 1})
+	patch.Normal = mat.NewVecDense(4, []float64{0, 0, -1, 0})
+	patch.RefPhoto = 0
+	patch.VPhotos = []int{1, 2}
+	patch.TPhotos = []int{1, 2}
+	patch.Scale = 1
+	return patch
+}
+
+func TestRegisterRemovePatchConcurrently(t *testing.T) {
+	defer SetObserver(observer)
+	SetObserver(nil)
+	defer useImagesManager(imgsManager)
+	manager := newTestManager(testProjMat(1, 0), testProjMat(1, -1),
+		testProjMat(1, -2))
+
+	const workers, patchesPerWorker = 8, 200
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(worker)))
+			for i := 0; i < patchesPerWorker; i++ {
+				patch := newTestPatch(rng.Float64()-0.5, rng.Float64()-0.5, 5)
+				if registered, _ := registerPatch(patch); !registered {
+					t.Errorf("patch %v wasn't registered", i)
+					continue
+				}
+				// every other patch is removed right away
+				if i%2 == 0 && !unregisterPatch(patch) {
+					t.Errorf("patch %v wasn't removed", patch.ID)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	manager.CompactPatches()
+
+	remaining := workers * patchesPerWorker / 2
+	if len(manager.Patches) != remaining {
+		t.Fatalf("%v patches remain, want %v", len(manager.Patches), remaining)
+	}
+	if manager.PatchIndex.Len() != remaining {
+		t.Errorf("%v patches indexed, want %v", manager.PatchIndex.Len(), remaining)
+	}
+	ids := make(map[int]bool)
+	for i, patch := range manager.Patches {
+		if patch.index != i {
+			t.Errorf("patch %v is at %v but has index %v", patch.ID, i, patch.index)
+		}
+		if ids[patch.ID] {
+			t.Errorf("id %v is given twice", patch.ID)
+		}
+		ids[patch.ID] = true
+	}
+	inCells := 0
+	for _, photo := range manager.Photos {
+		for _, row := range photo.Cells {
+			for _, cell := range row {
+				inCells += len(cell.Patches)
+			}
+		}
+	}
+	if inCells != 2*remaining {
+		t.Errorf("%v patches in cells, want %v", inCells, 2*remaining)
+	}
+}
+
+func TestRegisterPatchRejects(t *testing.T) {
+	defer SetObserver(observer)
+	SetObserver(nil)
+	defer useImagesManager(imgsManager)
+	manager := newTestManager(testProjMat(1, 0), testProjMat(1, -1),
+		testProjMat(1, -2))
+
+	atInfinity := newTestPatch(0, 0, 5)
+	atInfinity.Center.SetVec(3, 0)
+	notANumber := newTestPatch(math.NaN(), 0, 5)
+	behind := newTestPatch(0, 0, -5)
+	tests := []struct {
+		name  string
+		patch *Patch
+		want  RejectReason
+	}{
+		{"at infinity", atInfinity, RejectNonFinite},
+		{"not a number", notANumber, RejectNonFinite},
+		{"behind the photos", behind, RejectRefinedPhotos},
+	}
+	for _, test := range tests {
+		registered, reason := registerPatch(test.patch)
+		if registered || reason != test.want {
+			t.Errorf("%v: got registered %v for %q, want %q", test.name, registered,
+				reason, test.want)
+		}
+	}
+	if len(manager.Patches) != 0 || manager.PatchIndex.Len() != 0 {
+		t.Errorf("got %v patches and %v indexed, want none", len(manager.Patches),
+			manager.PatchIndex.Len())
+	}
+}
+
+func TestTextureCheck(t *testing.T) {
+	defer SetOptions(options)
+	defer useImagesManager(imgsManager)
+	manager := newTestManager(testProjMat(1, 0))
+	// the left half of the photo is flat, the right half ramps along x
+	slope := 0.02
+	img := manager.Photos[0].Img
+	for y := 0; y < testHeight; y++ {
+		for x := 0; x < testWidth; x++ {
+			val := 0.5 + slope*math.Max(float64(x-testWidth/2), 0)
+			for c := 0; c < 3; c++ {
+				img.Set(y, x, c, float32(val))
+			}
+		}
+	}
+	// a 5x5 window on the ramp has the variance of 5 values slope apart and
+	// a gradient of slope along x
+	variance := slope * slope * (5*5 - 1) / 12
+	energy := slope * slope
+	flat, ramp := newTestPatch(-3, 0, 5), newTestPatch(2.5, 0, 5)
+
+	tests := []struct {
+		name       string
+		measure    TextureMeasure
+		minTexture float64
+		patch      *Patch
+		want       bool
+	}{
+		{"disabled", TextureVariance, 0, flat, true},
+		{"flat variance", TextureVariance, 1e-6, flat, false},
+		{"ramp variance", TextureVariance, 0.9 * variance, ramp, true},
+		{"ramp variance too low", TextureVariance, 1.1 * variance, ramp, false},
+		{"flat gradient energy", TextureGradientEnergy, 1e-6, flat, false},
+		{"ramp gradient energy", TextureGradientEnergy, 0.9 * energy, ramp, true},
+		{"ramp gradient energy too low", TextureGradientEnergy, 1.1 * energy, ramp, false},
+	}
+	for _, test := range tests {
+		newOptions := NewOptions()
+		newOptions.TextureMeasure, newOptions.MinTexture = test.measure, test.minTexture
+		if err := SetOptions(newOptions); err != nil {
+			t.Fatal(err)
+		}
+		if got := textureCheck(test.patch); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
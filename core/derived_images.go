@@ -0,0 +1,100 @@
+package core
+
+import (
+	"pmvs/featdetect"
+	"pmvs/image"
+	"sync"
+)
+
+// derivedImages : The images derived from the image of a photo, computed on
+// first use. Photos sharing an image share them
+type derivedImages struct {
+	mutex     sync.Mutex
+	grayscale *image.CHWImage
+	luminance *image.CHWImage
+	gradX     *image.CHWImage
+	gradY     *image.CHWImage
+	integral  *image.Integral
+}
+
+// Grayscale : Returns image.Grayscale of the photo, which the Harris
+// detector works on
+func (photo *Photo) Grayscale() *image.CHWImage {
+	derived := photo.derived
+	derived.mutex.Lock()
+	defer derived.mutex.Unlock()
+	if derived.grayscale == nil {
+		derived.grayscale = image.Grayscale(photo.Img)
+	}
+	return derived.grayscale
+}
+
+// Luminance : Returns the luminance of the photo
+func (photo *Photo) Luminance() *image.CHWImage {
+	photo.derived.mutex.Lock()
+	defer photo.derived.mutex.Unlock()
+	return photo.luminanceLocked()
+}
+
+// Gradients : Returns the derivatives of the luminance along x and y
+func (photo *Photo) Gradients() (gradX, gradY *image.CHWImage) {
+	derived := photo.derived
+	derived.mutex.Lock()
+	defer derived.mutex.Unlock()
+	if derived.gradX == nil {
+		derived.gradX, derived.gradY = image.Gradients(photo.luminanceLocked())
+	}
+	return derived.gradX, derived.gradY
+}
+
+// Integral : Returns the summed area tables of the luminance, giving the
+// mean and variance of any window of the photo in constant time
+func (photo *Photo) Integral() *image.Integral {
+	photo.derived.mutex.Lock()
+	defer photo.derived.mutex.Unlock()
+	return photo.integralLocked()
+}
+
+// DetectFeatures : Detects the features of the photo, reusing its grayscale
+// image. The features are cached in FeaturesCacheDir unless it is empty,
+// failing to save them is only a warning
+func (photo *Photo) DetectFeatures() {
+	if options.FeaturesCacheDir == "" {
+		photo.Feats = featdetect.DetectFeaturesGray(photo.Img, photo.Grayscale(), photo.Mask)
+		return
+	}
+	feats, err := featdetect.DetectFeaturesGrayCached(photo.Img, photo.Grayscale(),
+		photo.Mask, options.FeaturesCacheDir)
+	if err != nil {
+		observer.Warning("features couldn't be cached", "photo", photo.ID,
+			"path", options.FeaturesCacheDir, "error", err)
+	}
+	photo.Feats = feats
+}
+
+// DetectFeatures : Detects the features of every photo
+func (imgsManager *ImagesManager) DetectFeatures() {
+	for _, photo := range imgsManager.Photos {
+		photo.DetectFeatures()
+	}
+}
+
+// resetDerived : Drops the images derived from Img. The photo gets derived
+// images of its own, as other photos may share the previous ones
+func (photo *Photo) resetDerived() {
+	photo.derived = new(derivedImages)
+}
+
+func (photo *Photo) luminanceLocked() *image.CHWImage {
+	if photo.derived.luminance == nil {
+		photo.derived.luminance = image.Luminance(photo.Img)
+	}
+	return photo.derived.luminance
+}
+
+func (photo *Photo) integralLocked() *image.Integral {
+	if photo.derived.integral == nil {
+		photo.derived.integral = image.NewIntegral(photo.luminanceLocked(), 0)
+	}
+	return photo.derived.integral
+}
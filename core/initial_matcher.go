@@ -1,33 +1,73 @@
 package core
 
 import (
-	"fmt"
+	"context"
 	"math"
 	"pmvs/featdetect"
 	"sort"
+	"time"
 
 	"gonum.org/v1/gonum/mat"
 )
 
-func StartMatching() {
-	fmt.Println("Initial Matching...")
+// StartMatching : Creates patches from the features of every photo, a
+// reconstruction restored from a checkpoint resumes at the photo it stopped.
+// If ctx is cancelled the matching stops between two features, keeps the
+// patches registered so far, saves a checkpoint and returns the error of ctx
+func StartMatching(ctx context.Context) error {
+	const phase = "initial matching"
+	if imgsManager.Phase != PhaseInitialMatching {
+		observer.Warning("initial matching is already done")
+		return nil
+	}
+	numPhotos := len(imgsManager.Photos)
+	imgsManager.Report = newMatchingReport(numPhotos)
+	observer.PhaseStarted(phase, numPhotos)
+	start, total := time.Now(), 0
 
-	for id, photo := range imgsManager.Photos {
+	for id := imgsManager.Iteration; id < numPhotos; id++ {
+		photoStart := time.Now()
+		photo := imgsManager.Photos[id]
 		num := 0
 		relevantImgs := getRelevantImages(id)
 		for _, featPool := range photo.Feats {
 			for _, feat := range featPool {
-				num += constructPatch(id, relevantImgs, feat)
+				if err := ctx.Err(); err != nil {
+					// the photo is matched again when resuming
+					checkpoint()
+					observer.PhaseEnded(phase, total+num, time.Since(start))
+					return err
+				}
+				if constructPatch(ctx, id, relevantImgs, feat) {
+					num++
+				}
 			}
 		}
-		fmt.Println("done img", id, " patches ", num)
+		total += num
+		observer.PhotoDone(phase, id, num, time.Since(photoStart))
+		observer.Progress(phase, id+1, numPhotos)
+		imgsManager.Iteration = id + 1
+		if options.CheckpointInterval > 0 &&
+			imgsManager.Iteration%options.CheckpointInterval == 0 {
+			checkpoint()
+		}
 	}
+	imgsManager.Phase, imgsManager.Iteration = PhaseCompleted, 0
+	checkpoint()
+	observer.PhaseEnded(phase, total, time.Since(start))
+	return nil
 }
 
-func constructPatch(photoID int, relevantImgs []int, feat *featdetect.Feature) int {
+// constructPatch : Tries to create a patch from the feature and the features
+// near its epipolar lines, nearest in depth first. The outcome is counted
+// in the matching report
+func constructPatch(ctx context.Context, photoID int, relevantImgs []int, feat *featdetect.Feature) bool {
+	report := imgsManager.Report
 	cell := getCell(photoID, feat.Y, feat.X)
-	if len(cell.Patches) != 0 {
-		return 0
+	if cell == nil || len(cell.Patches) != 0 {
+		report.add(photoID, RejectOccupied)
+		observer.PatchRejected(RejectOccupied)
+		return false
 	}
 	type FeatSort struct {
 		feature  *featdetect.Feature
@@ -38,13 +78,22 @@ func constructPatch(photoID int, relevantImgs []int, feat *featdetect.Feature) i
 	photo := imgsManager.Photos[photoID]
 	opticalCenter := photo.OpticalCenter()
 	relevantFeats, ids := getRelevantFeatures(feat, photoID, relevantImgs)
+	report.CandidatesHistogram.Add(float64(len(relevantFeats)))
+	if len(relevantFeats) == 0 {
+		report.add(photoID, RejectNoCandidates)
+		observer.PatchRejected(RejectNoCandidates)
+		return false
+	}
 
 	featDataFiltered := make([]FeatSort, 0, len(relevantFeats))
 
+	// the furthest stage reached by the candidates
+	reason := RejectOccupied
 	depthVector1, depthVector2 := mat.NewVecDense(4, nil), mat.NewVecDense(4, nil)
 	for feat2Id, feat2 := range relevantFeats {
 		cell = getCell(ids[feat2Id], feat2.Y, feat2.X)
-		if len(cell.Patches) != 0 {
+		if cell == nil || len(cell.Patches) != 0 {
+			observer.PatchRejected(RejectOccupied)
 			continue
 		}
 
@@ -53,6 +102,14 @@ func constructPatch(photoID int, relevantImgs []int, feat *featdetect.Feature) i
 		center.ScaleVec(1/center.AtVec(3), center)
 
 		if !visualHullCheck(center) {
+			reason = furthestReason(reason, RejectHull)
+			observer.PatchRejected(RejectHull)
+			continue
+		}
+		if !roiCheck(center) {
+			imgsManager.RejectedByROI++
+			reason = furthestReason(reason, RejectROI)
+			observer.PatchRejected(RejectROI)
 			continue
 		}
 
@@ -76,19 +133,59 @@ func constructPatch(photoID int, relevantImgs []int, feat *featdetect.Feature) i
 	patch.RefPhoto = photoID
 	for _, ff := range featDataFiltered {
 		patch.Center = ff.pos3d
-		patch.Normal.SubVec(opticalCenter, patch.Center)
-		patch.Normal.ScaleVec(1/math.Sqrt(mat.Dot(patch.Normal, patch.Normal)),
-			patch.Normal)
-		patch.TPhotos = constraintPhotos(patch, 0.6, relevantImgs)
-		if len(patch.TPhotos) <= 1 {
-			continue
+		registered, candidateReason := refinePatch(ctx, patch, relevantImgs)
+		if registered {
+			report.add(photoID, "")
+			return true
 		}
-		optimizePatch(patch)
-		patch.TPhotos = constraintPhotos(patch, 0.7, relevantImgs)
-		if len(patch.TPhotos) >= 3 {
-			registerPatch(patch)
-			return 1
+		if ctx.Err() != nil {
+			// interrupted features are matched again when resuming
+			return false
 		}
+		reason = furthestReason(reason, candidateReason)
+	}
+	report.add(photoID, reason)
+	return false
+}
+
+// refinePatch : Points the patch normal to its reference photo, optimizes
+// the patch and registers it if it is seen by enough photos. Otherwise the
+// reason of the rejection is returned, patches whose optimization is
+// interrupted by ctx aren't registered and have no reason
+func refinePatch(ctx context.Context, patch *Patch, relevantImgs []int) (registered bool, reason RejectReason) {
+	var initialNCC, refinedNCC *Histogram
+	if imgsManager.Report != nil {
+		initialNCC, refinedNCC = imgsManager.Report.InitialNCC, imgsManager.Report.RefinedNCC
+	}
+	refPhoto := imgsManager.Photos[patch.RefPhoto]
+	patch.Normal.SubVec(refPhoto.OpticalCenter(), patch.Center)
+	norm := math.Sqrt(mat.Dot(patch.Normal, patch.Normal))
+	if norm == 0 {
+		// the patch is at the camera, face the camera anyway
+		patch.Normal.ScaleVec(-1, refPhoto.Cam.ViewDirection())
+	} else {
+		patch.Normal.ScaleVec(1/norm, patch.Normal)
+	}
+	adaptPatchScale(patch)
+	if !textureCheck(patch) {
+		observer.PatchRejected(RejectTexture)
+		return false, RejectTexture
+	}
+	patch.TPhotos = constraintPhotos(patch, 0.6, relevantImgs, initialNCC)
+	if len(patch.TPhotos) <= 1 {
+		observer.PatchRejected(RejectInitialPhotos)
+		return false, RejectInitialPhotos
+	}
+	optimizePatch(ctx, patch)
+	if ctx.Err() != nil {
+		return false, ""
+	}
+	patch.TPhotos = constraintPhotos(patch, 0.7, relevantImgs, refinedNCC)
+	if len(patch.TPhotos) < 3 {
+		observer.PatchRejected(RejectRefinedPhotos)
+		return false, RejectRefinedPhotos
 	}
-	return 0
+	patch.VPhotos = visiblePhotos(patch, relevantImgs)
+	patch.Score, patch.Size = patchScore(patch)
+	return registerPatch(patch)
 }
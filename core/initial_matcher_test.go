@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"pmvs/featdetect"
+	"reflect"
+	"testing"
+)
+
+// rejectObserver : Counts the rejections
+type rejectObserver struct {
+	BaseObserver
+	rejected map[RejectReason]int
+}
+
+func (observer *rejectObserver) PatchRejected(reason RejectReason) {
+	observer.rejected[reason]++
+}
+
+func TestConstructPatchRejections(t *testing.T) {
+	defer SetObserver(observer)
+	defer useImagesManager(imgsManager)
+	occupied := newTestPatch(0, 0, 5)
+
+	tests := []struct {
+		name string
+		// feature of the first photo
+		x, y int
+		// whether the cells of the feature and of the first candidate
+		// hold a patch
+		featureOccupied, candidateOccupied bool
+		want                               map[RejectReason]int
+		wantReport                         RejectReason
+	}{
+		{"every candidate", 20, 15, false, false,
+			map[RejectReason]int{RejectHull: 3}, RejectHull},
+		{"occupied candidate", 20, 15, false, true,
+			map[RejectReason]int{RejectOccupied: 1, RejectHull: 2}, RejectHull},
+		{"occupied feature", 20, 15, true, false,
+			map[RejectReason]int{RejectOccupied: 1}, RejectOccupied},
+		{"no candidates", 20, 2, false, false,
+			map[RejectReason]int{RejectNoCandidates: 1}, RejectNoCandidates},
+	}
+	for _, test := range tests {
+		// the cameras are side by side along x, epipolar lines are rows. The
+		// masks are empty so every candidate is outside the visual hull
+		manager := newTestManager(testProjMat(1, 0), testProjMat(1, -1), testProjMat(1, -2))
+		manager.Report = newMatchingReport(len(manager.Photos))
+		feat := featdetect.NewFeature(test.x, test.y, 1, featdetect.DoG)
+		candidates := [][]*featdetect.Feature{
+			{featdetect.NewFeature(15, 15, 1, featdetect.DoG),
+				featdetect.NewFeature(10, 15, 1, featdetect.DoG)},
+			{featdetect.NewFeature(12, 15, 1, featdetect.DoG)},
+		}
+		manager.Photos[0].Feats = [][]*featdetect.Feature{{feat}, nil}
+		for i, feats := range candidates {
+			manager.Photos[i+1].Feats = [][]*featdetect.Feature{feats, nil}
+		}
+		if test.featureOccupied {
+			cell := manager.Photos[0].cellAt(feat.Y, feat.X)
+			cell.Patches = append(cell.Patches, occupied)
+		}
+		if test.candidateOccupied {
+			cell := manager.Photos[1].cellAt(candidates[0][0].Y, candidates[0][0].X)
+			cell.Patches = append(cell.Patches, occupied)
+		}
+		recorder := &rejectObserver{rejected: make(map[RejectReason]int)}
+		SetObserver(recorder)
+
+		if constructPatch(context.Background(), 0, []int{1, 2}, feat) {
+			t.Fatalf("%v: a patch was created", test.name)
+		}
+		// the observer sees every candidate, the report every feature
+		if !reflect.DeepEqual(recorder.rejected, test.want) {
+			t.Errorf("%v: got rejections %v, want %v", test.name, recorder.rejected, test.want)
+		}
+		wantReport := map[RejectReason]int{test.wantReport: 1}
+		if got := manager.Report.Total.Rejected; !reflect.DeepEqual(got, wantReport) {
+			t.Errorf("%v: got report rejections %v, want %v", test.name, got, wantReport)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	histogramBins = 20
+	// epipolar candidates beyond this count fall in the last bin
+	maxCandidates = 100
+)
+
+// rejectReasons : Every reason in the order of the stages of the matching,
+// a feature is rejected for the furthest stage one of its candidates reached
+var rejectReasons = []RejectReason{
+	RejectOccupied, RejectNoCandidates, RejectHull, RejectROI, RejectTexture,
+	RejectInitialPhotos, RejectNonFinite, RejectRefinedPhotos,
+}
+
+// Histogram : Counts values in bins of equal width between Min and Max,
+// values outside the range are counted in the first and last bins
+type Histogram struct {
+	Min    float64
+	Max    float64
+	Counts []int
+}
+
+// NewHistogram : Creates new empty histogram
+func NewHistogram(min, max float64, bins int) *Histogram {
+	if bins <= 0 || max <= min {
+		panic("Histogram should have bins and a non empty range")
+	}
+	histogram := new(Histogram)
+	histogram.Min, histogram.Max = min, max
+	histogram.Counts = make([]int, bins)
+	return histogram
+}
+
+// Add : Counts the value
+func (histogram *Histogram) Add(val float64) {
+	bins := len(histogram.Counts)
+	bin := int(float64(bins) * (val - histogram.Min) / (histogram.Max - histogram.Min))
+	histogram.Counts[minInt(maxInt(bin, 0), bins-1)]++
+}
+
+// Total : Returns the number of values counted
+func (histogram *Histogram) Total() (total int) {
+	for _, count := range histogram.Counts {
+		total += count
+	}
+	return
+}
+
+// merge : Adds the counts of a histogram with the same bins
+func (histogram *Histogram) merge(histogram2 *Histogram) {
+	for i, count := range histogram2.Counts {
+		histogram.Counts[i] += count
+	}
+}
+
+// String : Returns one line per bin with its range, count and a bar
+func (histogram *Histogram) String() string {
+	var builder strings.Builder
+	width := (histogram.Max - histogram.Min) / float64(len(histogram.Counts))
+	maxCount := 1
+	for _, count := range histogram.Counts {
+		maxCount = maxInt(maxCount, count)
+	}
+	for i, count := range histogram.Counts {
+		low := histogram.Min + float64(i)*width
+		fmt.Fprintf(&builder, "[%7.2f, %7.2f) %8d %s\n", low, low+width, count,
+			strings.Repeat("#", 40*count/maxCount))
+	}
+	return builder.String()
+}
+
+// PhotoReport : Outcome of the features of a photo. Every feature either
+// creates a patch or is rejected for one reason
+type PhotoReport struct {
+	Features int
+	Patches  int
+	Rejected map[RejectReason]int
+}
+
+// MatchingReport : Statistics of the initial matching, per photo and over
+// all the photos, along with histograms of the NCC scores of the photos
+// checked before and after the optimization of patches, and of the number
+// of epipolar candidates of features. A resumed matching only reports the
+// photos matched after resuming
+type MatchingReport struct {
+	Photos              []*PhotoReport
+	Total               *PhotoReport
+	InitialNCC          *Histogram
+	RefinedNCC          *Histogram
+	CandidatesHistogram *Histogram
+}
+
+func newPhotoReport() *PhotoReport {
+	report := new(PhotoReport)
+	report.Rejected = make(map[RejectReason]int)
+	return report
+}
+
+// newMatchingReport : Creates new empty report for numPhotos photos
+func newMatchingReport(numPhotos int) *MatchingReport {
+	report := new(MatchingReport)
+	report.Photos = make([]*PhotoReport, numPhotos)
+	for i := range report.Photos {
+		report.Photos[i] = newPhotoReport()
+	}
+	report.Total = newPhotoReport()
+	report.InitialNCC = NewHistogram(-1, 1, histogramBins)
+	report.RefinedNCC = NewHistogram(-1, 1, histogramBins)
+	report.CandidatesHistogram = NewHistogram(0, maxCandidates, histogramBins)
+	return report
+}
+
+// add : Counts the outcome of a feature of the photo, an empty reason
+// means the feature created a patch
+func (report *MatchingReport) add(photoID int, reason RejectReason) {
+	for _, photoReport := range []*PhotoReport{report.Photos[photoID], report.Total} {
+		photoReport.Features++
+		if reason == "" {
+			photoReport.Patches++
+		} else {
+			photoReport.Rejected[reason]++
+		}
+	}
+}
+
+// merge : Adds a report whose photo i is photo ids[i] of this report
+func (report *MatchingReport) merge(report2 *MatchingReport, ids []int) {
+	for i, photoReport := range report2.Photos {
+		report.Photos[ids[i]].merge(photoReport)
+	}
+	report.Total.merge(report2.Total)
+	report.InitialNCC.merge(report2.InitialNCC)
+	report.RefinedNCC.merge(report2.RefinedNCC)
+	report.CandidatesHistogram.merge(report2.CandidatesHistogram)
+}
+
+func (report *PhotoReport) merge(report2 *PhotoReport) {
+	report.Features += report2.Features
+	report.Patches += report2.Patches
+	for reason, count := range report2.Rejected {
+		report.Rejected[reason] += count
+	}
+}
+
+// String : Returns the counters of the photo in one line
+func (report *PhotoReport) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "features %d patches %d", report.Features, report.Patches)
+	for _, reason := range rejectReasons {
+		fmt.Fprintf(&builder, ", %s %d", reason, report.Rejected[reason])
+	}
+	return builder.String()
+}
+
+// String : Returns the report in a human readable form
+func (report *MatchingReport) String() string {
+	var builder strings.Builder
+	fmt.Fprintln(&builder, "total:", report.Total)
+	for i, photoReport := range report.Photos {
+		fmt.Fprintf(&builder, "photo %d: %s\n", i, photoReport)
+	}
+	fmt.Fprint(&builder, "NCC before optimization:\n", report.InitialNCC)
+	fmt.Fprint(&builder, "NCC after optimization:\n", report.RefinedNCC)
+	fmt.Fprint(&builder, "epipolar candidates per feature:\n", report.CandidatesHistogram)
+	return builder.String()
+}
+
+// furthestReason : Returns whichever reason comes later in the matching
+func furthestReason(reason, reason2 RejectReason) RejectReason {
+	rank := func(reason RejectReason) int {
+		for i, reason2 := range rejectReasons {
+			if reason == reason2 {
+				return i
+			}
+		}
+		return -1
+	}
+	if rank(reason2) > rank(reason) {
+		return reason2
+	}
+	return reason
+}
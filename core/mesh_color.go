@@ -0,0 +1,171 @@
+package core
+
+import (
+	"math"
+	"pmvs/mesh"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// relative depth by which a vertex may lie behind the depth buffer and
+	// still be seen, for the curvature of the mesh between pixels
+	depthBufferTolerance = 0.01
+)
+
+// ColorMesh : Sets the color of every vertex of the mesh to the average of
+// its colors in the photos that see it. A photo sees a vertex if the vertex
+// projects inside it, is not masked, faces its camera and isn't hidden by
+// the mesh itself, which is rendered in a depth buffer for every photo.
+// Views are weighted by the cosine of the angle between the normal and the
+// view ray
+func ColorMesh(m *mesh.Mesh) {
+	if !m.HasNormals() {
+		m.ComputeNormals()
+	}
+	m.Colors = make([][3]float32, len(m.Vertices))
+	sums := make([][4]float64, len(m.Vertices))
+
+	point := mat.NewVecDense(4, nil)
+	ray := mat.NewVecDense(4, nil)
+	for _, photo := range imgsManager.Photos {
+		view := projectMesh(m, photo)
+		buffer := view.depthBuffer(m)
+		for i, vertex := range m.Vertices {
+			if view.depths[i] <= 0 {
+				continue
+			}
+			point.SetVec(0, vertex[0])
+			point.SetVec(1, vertex[1])
+			point.SetVec(2, vertex[2])
+			point.SetVec(3, 1)
+			normal := m.Normals[i]
+			ray.SubVec(photo.OpticalCenter(), point)
+			rayLength := mat.Norm(ray, 2)
+			if rayLength == 0 {
+				continue
+			}
+			cosAngle := (ray.AtVec(0)*normal[0] + ray.AtVec(1)*normal[1] +
+				ray.AtVec(2)*normal[2]) / rayLength
+			if cosAngle <= 0 {
+				continue
+			}
+
+			x, y := view.xs[i], view.ys[i]
+			if !photo.Contains(y, x) {
+				continue
+			}
+			if photo.Mask != nil && photo.IsMasked(y, x) {
+				continue
+			}
+			if !view.visible(buffer, i) {
+				continue
+			}
+			pr, pg, pb := photo.At(y, x)
+			sums[i][0] += cosAngle * float64(pr)
+			sums[i][1] += cosAngle * float64(pg)
+			sums[i][2] += cosAngle * float64(pb)
+			sums[i][3] += cosAngle
+		}
+	}
+	for i, sum := range sums {
+		if sum[3] == 0 {
+			continue
+		}
+		m.Colors[i] = [3]float32{
+			float32(sum[0] / sum[3]), float32(sum[1] / sum[3]), float32(sum[2] / sum[3]),
+		}
+	}
+}
+
+// meshView : The vertices of a mesh projected in a photo, vertices behind
+// the camera have a depth of 0
+type meshView struct {
+	width  int
+	height int
+	xs     []float64
+	ys     []float64
+	depths []float64
+}
+
+// projectMesh : Projects the vertices of the mesh in the photo
+func projectMesh(m *mesh.Mesh, photo *Photo) *meshView {
+	view := new(meshView)
+	view.width, view.height = photo.Img.Width, photo.Img.Height
+	view.xs = make([]float64, len(m.Vertices))
+	view.ys = make([]float64, len(m.Vertices))
+	view.depths = make([]float64, len(m.Vertices))
+	point := mat.NewVecDense(4, nil)
+	projected := mat.NewVecDense(3, nil)
+	for i, vertex := range m.Vertices {
+		point.SetVec(0, vertex[0])
+		point.SetVec(1, vertex[1])
+		point.SetVec(2, vertex[2])
+		point.SetVec(3, 1)
+		if !photo.Cam.InFront(point) {
+			continue
+		}
+		projected.MulVec(photo.CameraMatrix(), point)
+		view.xs[i] = projected.AtVec(0) / projected.AtVec(2)
+		view.ys[i] = projected.AtVec(1) / projected.AtVec(2)
+		view.depths[i] = pointDepth(photo, point)
+	}
+	return view
+}
+
+// depthBuffer : Returns the depth of the nearest face at the center of
+// every pixel, row by row, +Inf where no face is seen. Faces with a vertex
+// behind the camera are left out
+func (view *meshView) depthBuffer(m *mesh.Mesh) []float64 {
+	buffer := make([]float64, view.width*view.height)
+	for i := range buffer {
+		buffer[i] = math.Inf(1)
+	}
+	for _, face := range m.Faces {
+		v0, v1, v2 := face[0], face[1], face[2]
+		if view.depths[v0] <= 0 || view.depths[v1] <= 0 || view.depths[v2] <= 0 {
+			continue
+		}
+		x0, y0, x1, y1, x2, y2 := view.xs[v0], view.ys[v0], view.xs[v1], view.ys[v1],
+			view.xs[v2], view.ys[v2]
+		area := (x1-x0)*(y2-y0) - (x2-x0)*(y1-y0)
+		if area == 0 {
+			continue
+		}
+		minX := maxInt(int(math.Ceil(math.Min(x0, math.Min(x1, x2)))), 0)
+		maxX := minInt(int(math.Floor(math.Max(x0, math.Max(x1, x2)))), view.width-1)
+		minY := maxInt(int(math.Ceil(math.Min(y0, math.Min(y1, y2)))), 0)
+		maxY := minInt(int(math.Floor(math.Max(y0, math.Max(y1, y2)))), view.height-1)
+		for y := minY; y <= maxY; y++ {
+			for x := minX; x <= maxX; x++ {
+				px, py := float64(x), float64(y)
+				b0 := ((x1-px)*(y2-py) - (x2-px)*(y1-py)) / area
+				b1 := ((x2-px)*(y0-py) - (x0-px)*(y2-py)) / area
+				b2 := 1 - b0 - b1
+				if b0 < 0 || b1 < 0 || b2 < 0 {
+					continue
+				}
+				// the inverse depth is affine in the image
+				depth := 1 / (b0/view.depths[v0] + b1/view.depths[v1] + b2/view.depths[v2])
+				if index := y*view.width + x; depth < buffer[index] {
+					buffer[index] = depth
+				}
+			}
+		}
+	}
+	return buffer
+}
+
+// visible : Returns whether vertex i isn't behind the depth buffer at the
+// centers of the pixels around it. Where the mesh is flat and unoccluded
+// the depth of the vertex is at most the largest of these depths
+func (view *meshView) visible(buffer []float64, i int) bool {
+	x0, y0 := int(math.Floor(view.xs[i])), int(math.Floor(view.ys[i]))
+	limit := math.Inf(-1)
+	for y := maxInt(y0, 0); y <= minInt(y0+1, view.height-1); y++ {
+		for x := maxInt(x0, 0); x <= minInt(x0+1, view.width-1); x++ {
+			limit = math.Max(limit, buffer[y*view.width+x])
+		}
+	}
+	return view.depths[i] <= limit*(1+depthBufferTolerance)
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"pmvs/mesh"
+	"testing"
+)
+
+// addQuad : Adds the square of given half side centered at (0, 0, z) and
+// facing the cameras, returns its vertices
+func addQuad(m *mesh.Mesh, halfSide, z float64) []int {
+	v0 := m.AddVertex(-halfSide, -halfSide, z)
+	v1 := m.AddVertex(halfSide, -halfSide, z)
+	v2 := m.AddVertex(halfSide, halfSide, z)
+	v3 := m.AddVertex(-halfSide, halfSide, z)
+	m.AddFace(v0, v2, v1)
+	m.AddFace(v0, v3, v2)
+	return []int{v0, v1, v2, v3}
+}
+
+func TestColorMeshOcclusion(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	// a red photo looking along z from the origin and a green one from
+	// (3, 0, 0)
+	manager := newTestManager(testProjMat(1, 0), testProjMat(1, -3))
+	for _, photo := range manager.Photos {
+		for y := 0; y < testHeight; y++ {
+			for x := 0; x < testWidth; x++ {
+				photo.Img.Set(y, x, photo.ID, 1)
+				photo.Mask.Set(y, x, 0, 1)
+			}
+		}
+	}
+
+	// the front square hides the back one from the red photo only
+	m := mesh.NewMesh()
+	back := addQuad(m, 0.5, 5)
+	front := addQuad(m, 0.6, 3)
+	ColorMesh(m)
+	for _, v := range back {
+		if color := m.Colors[v]; color != [3]float32{0, 1, 0} {
+			t.Errorf("hidden vertex %v has color %v, want green", m.Vertices[v], color)
+		}
+	}
+	for _, v := range front {
+		if color := m.Colors[v]; color[0] == 0 {
+			t.Errorf("front vertex %v has color %v, want some red", m.Vertices[v], color)
+		}
+	}
+
+	// without the front square both photos see the back one
+	m = mesh.NewMesh()
+	back = addQuad(m, 0.5, 5)
+	ColorMesh(m)
+	for _, v := range back {
+		if color := m.Colors[v]; color[0] == 0 || color[1] == 0 {
+			t.Errorf("vertex %v has color %v, want red and green", m.Vertices[v], color)
+		}
+	}
+}
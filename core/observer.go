@@ -0,0 +1,200 @@
+package core
+
+import (
+	"log/slog"
+	"time"
+)
+
+// RejectReason : Why a candidate patch was rejected
+type RejectReason string
+
+const (
+	// RejectOccupied : The cell of the feature, or of the feature matched
+	// by a candidate, already holds a patch
+	RejectOccupied RejectReason = "occupied cell"
+	// RejectNoCandidates : No feature lies near the epipolar lines
+	RejectNoCandidates RejectReason = "no epipolar candidates"
+	// RejectHull : The patch center is outside the visual hull
+	RejectHull RejectReason = "visual hull"
+	// RejectROI : The patch center is outside the region of interest
+	RejectROI RejectReason = "region of interest"
+	// RejectTexture : The window of the patch in its reference photo is
+	// too uniform to be matched
+	RejectTexture RejectReason = "low texture"
+	// RejectInitialPhotos : Too few photos agree with the patch before
+	// its optimization
+	RejectInitialPhotos RejectReason = "too few photos before optimization"
+	// RejectNonFinite : The optimization moved the patch center to
+	// infinity or to a point that isn't a number
+	RejectNonFinite RejectReason = "non finite center"
+	// RejectRefinedPhotos : Too few photos agree with the patch after its
+	// optimization
+	RejectRefinedPhotos RejectReason = "too few photos after optimization"
+)
+
+// Observer : Receives the progress of the reconstruction. Phases are named
+// by strings such as "initial matching" and count their work in items,
+// photos for the matching, sparse points for the seeding and so on
+type Observer interface {
+	// PhaseStarted : A phase starts working on total items
+	PhaseStarted(phase string, total int)
+	// Progress : The phase finished done of its total items
+	Progress(phase string, done, total int)
+	// PhotoDone : The phase finished the photo, creating patches
+	PhotoDone(phase string, photoID, patches int, elapsed time.Duration)
+	// PatchCreated : The patch was registered
+	PatchCreated(patch *Patch)
+	// PatchRejected : A candidate patch was dropped. Features dropped before
+	// any candidate is built, because their cell is occupied or they have no
+	// epipolar candidates, are reported once
+	PatchRejected(reason RejectReason)
+	// PhaseEnded : The phase finished, creating patches
+	PhaseEnded(phase string, patches int, elapsed time.Duration)
+	// Warning : Something went wrong without stopping the reconstruction,
+	// args are key value pairs as in log/slog
+	Warning(msg string, args ...any)
+}
+
+// BaseObserver : Ignores every event, observers can embed it to only
+// implement the events they need
+type BaseObserver struct{}
+
+// PhaseStarted : Does nothing
+func (BaseObserver) PhaseStarted(phase string, total int) {}
+
+// Progress : Does nothing
+func (BaseObserver) Progress(phase string, done, total int) {}
+
+// PhotoDone : Does nothing
+func (BaseObserver) PhotoDone(phase string, photoID, patches int, elapsed time.Duration) {}
+
+// PatchCreated : Does nothing
+func (BaseObserver) PatchCreated(patch *Patch) {}
+
+// PatchRejected : Does nothing
+func (BaseObserver) PatchRejected(reason RejectReason) {}
+
+// PhaseEnded : Does nothing
+func (BaseObserver) PhaseEnded(phase string, patches int, elapsed time.Duration) {}
+
+// Warning : Does nothing
+func (BaseObserver) Warning(msg string, args ...any) {}
+
+// LogObserver : Logs the events to a structured logger. Phases and photos
+// are logged at the info level, patches at the debug level, and the
+// rejections are counted and logged at the end of each phase
+type LogObserver struct {
+	logger   *slog.Logger
+	rejected map[RejectReason]int
+}
+
+// NewLogObserver : Creates new observer logging to logger
+func NewLogObserver(logger *slog.Logger) *LogObserver {
+	observer := new(LogObserver)
+	observer.logger = logger
+	observer.rejected = make(map[RejectReason]int)
+	return observer
+}
+
+// PhaseStarted : Logs the start of the phase
+func (observer *LogObserver) PhaseStarted(phase string, total int) {
+	observer.logger.Info("phase started", "phase", phase, "total", total)
+}
+
+// Progress : Logs the progress at the debug level
+func (observer *LogObserver) Progress(phase string, done, total int) {
+	observer.logger.Debug("progress", "phase", phase, "done", done, "total", total)
+}
+
+// PhotoDone : Logs the patches created from the photo
+func (observer *LogObserver) PhotoDone(phase string, photoID, patches int, elapsed time.Duration) {
+	observer.logger.Info("photo done", "phase", phase, "photo", photoID,
+		"patches", patches, "elapsed", elapsed)
+}
+
+// PatchCreated : Logs the patch at the debug level
+func (observer *LogObserver) PatchCreated(patch *Patch) {
+	observer.logger.Debug("patch created", "photo", patch.RefPhoto,
+		"photos", len(patch.TPhotos))
+}
+
+// PatchRejected : Counts the rejection
+func (observer *LogObserver) PatchRejected(reason RejectReason) {
+	observer.rejected[reason]++
+}
+
+// PhaseEnded : Logs the end of the phase along with the rejections counted
+// since the last phase ended
+func (observer *LogObserver) PhaseEnded(phase string, patches int, elapsed time.Duration) {
+	var rejected []any
+	for _, reason := range rejectReasons {
+		rejected = append(rejected, slog.Int(string(reason), observer.rejected[reason]))
+	}
+	observer.logger.Info("phase ended", "phase", phase, "patches", patches,
+		"elapsed", elapsed, slog.Group("rejected", rejected...))
+	observer.rejected = make(map[RejectReason]int)
+}
+
+// Warning : Logs the warning
+func (observer *LogObserver) Warning(msg string, args ...any) {
+	observer.logger.Warn(msg, args...)
+}
+
+// multiObserver : Forwards every event to several observers
+type multiObserver []Observer
+
+// MultiObserver : Creates an observer forwarding every event to observers
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(append([]Observer(nil), observers...))
+}
+
+func (observers multiObserver) PhaseStarted(phase string, total int) {
+	for _, observer := range observers {
+		observer.PhaseStarted(phase, total)
+	}
+}
+
+func (observers multiObserver) Progress(phase string, done, total int) {
+	for _, observer := range observers {
+		observer.Progress(phase, done, total)
+	}
+}
+
+func (observers multiObserver) PhotoDone(phase string, photoID, patches int, elapsed time.Duration) {
+	for _, observer := range observers {
+		observer.PhotoDone(phase, photoID, patches, elapsed)
+	}
+}
+
+func (observers multiObserver) PatchCreated(patch *Patch) {
+	for _, observer := range observers {
+		observer.PatchCreated(patch)
+	}
+}
+
+func (observers multiObserver) PatchRejected(reason RejectReason) {
+	for _, observer := range observers {
+		observer.PatchRejected(reason)
+	}
+}
+
+func (observers multiObserver) PhaseEnded(phase string, patches int, elapsed time.Duration) {
+	for _, observer := range observers {
+		observer.PhaseEnded(phase, patches, elapsed)
+	}
+}
+
+func (observers multiObserver) Warning(msg string, args ...any) {
+	for _, observer := range observers {
+		observer.Warning(msg, args...)
+	}
+}
+
+// SetObserver : Sets the observer notified by the package, nil silences
+// the package
+func SetObserver(newObserver Observer) {
+	if newObserver == nil {
+		newObserver = BaseObserver{}
+	}
+	observer = newObserver
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"math"
 
 	"gonum.org/v1/gonum/mat"
@@ -11,6 +12,7 @@ var (
 	photo       *Photo
 	depthVec    *mat.VecDense
 	optimPhotos []int
+	optimScale  float64
 )
 
 func encode(center, normal *mat.VecDense,
@@ -56,34 +58,53 @@ func decode(photo *Photo, unitDepthVec *mat.VecDense,
 }
 
 func nccObjective(center, right, up *mat.VecDense, refPhoto *Photo, targetPhotos []int) float64 {
-	cell1 := make([]float32, patchGridSize*patchGridSize*3)
-	cell2 := make([]float32, patchGridSize*patchGridSize*3)
+	gridSize := options.WindowSize
+	cell1 := make([]float32, gridSize*gridSize*3)
+	cell2 := make([]float32, gridSize*gridSize*3)
 
-	cell1 = projectGrid(refPhoto, center, right, up, patchGridSize, cell1)
+	cell1 = projectGrid(refPhoto, center, right, up, gridSize, cell1)
 
-	var totNcc float64
-	for _, id := range targetPhotos {
+	scores := make([]float64, len(targetPhotos))
+	for i, id := range targetPhotos {
 		photo := imgsManager.Photos[id]
-		cell2 = projectGrid(photo, center, right, up, patchGridSize, cell2)
-		totNcc += ncc(cell1, cell2)
+		cell2 = projectGrid(photo, center, right, up, gridSize, cell2)
+		scores[i] = photoScore(cell1, cell2)
 	}
-	return totNcc / float64(len(targetPhotos))
+	return aggregateScores(scores)
 }
 
 func objectiveWrapper(x []float64) float64 {
 	depth, theta, phi := x[0], x[1], x[2]
 	center, normal := decode(photo, depthVec, depth, theta, phi)
-	if !visualHullCheck(center) {
+	if !visualHullCheck(center) || !roiCheck(center) {
 		return 1.0
 	}
 	if mat.Dot(depthVec, photo.OpticalAxis()) < 0 {
 		return 1.0
 	}
-	right, up := getPatchVectors(photo, center, normal)
+	right, up := getPatchVectors(photo, center, normal, optimScale)
 	return -nccObjective(center, right, up, photo, optimPhotos)
 }
 
-func optimizePatch(patch *Patch) {
+// contextConverger : Stops the optimization when the context is cancelled,
+// otherwise defers to the wrapped converger
+type contextConverger struct {
+	ctx context.Context
+	optimize.Converger
+}
+
+// Converged : Checks the context before the wrapped converger
+func (converger *contextConverger) Converged(loc *optimize.Location) optimize.Status {
+	if converger.ctx.Err() != nil {
+		return optimize.RuntimeLimit
+	}
+	return converger.Converger.Converged(loc)
+}
+
+// optimizePatch : Maximizes the photometric consistency of the patch over
+// its depth and normal. If ctx is cancelled the optimization stops early
+// and the patch is left unchanged
+func optimizePatch(ctx context.Context, patch *Patch) {
 	refPhoto := imgsManager.Photos[patch.RefPhoto]
 	opticalCenter := refPhoto.OpticalCenter()
 
@@ -91,7 +112,7 @@ func optimizePatch(patch *Patch) {
 		encode(patch.Center, patch.Normal, refPhoto, opticalCenter)
 	depth, unitDepthVec = normalize(depth, unitDepthVec, patch.TPhotos)
 	targetPhotos := patch.TPhotos
-	photo, depthVec, optimPhotos = refPhoto, unitDepthVec, targetPhotos
+	photo, depthVec, optimPhotos, optimScale = refPhoto, unitDepthVec, targetPhotos, patch.Scale
 
 	problem := optimize.Problem{
 		Func: objectiveWrapper,
@@ -107,10 +128,14 @@ func optimizePatch(patch *Patch) {
 	}
 	settings := optimize.Settings{
 		MajorIterations: 1000,
-		Converger:       &converger,
+		Converger:       &contextConverger{ctx, &converger},
 	}
 
 	result, _ := optimize.Minimize(problem, []float64{depth, theta, phi}, &settings, &method)
+	if ctx.Err() != nil {
+		return
+	}
+	patch.Status = result.Status
 	center, normal := decode(refPhoto, unitDepthVec, result.Location.X[0],
 		result.Location.X[1], result.Location.X[2])
 	patch.Center, patch.Normal = center, normal
@@ -0,0 +1,197 @@
+package core
+
+import "errors"
+
+var (
+	errWindowSize     = errors.New("Error! Options window size should be odd and at least 3")
+	errBilateralSigma = errors.New("Error! Options bilateral sigmas should be positive")
+	errSSDNoise       = errors.New("Error! Options SSD noise should be positive")
+	errTruncated      = errors.New("Error! Options truncated fraction should be in [0, 1)")
+	errOcclusionSigma = errors.New("Error! Options occlusion sigma should be positive")
+	errMaxScoreDrop   = errors.New("Error! Options max score drop shouldn't be negative")
+	errNumNeighbours  = errors.New("Error! Options number of neighbours should be at least 1")
+	errCheckpoint     = errors.New("Error! Options checkpoint interval shouldn't be negative")
+	errPhotoMeasure   = errors.New("Error! Options photo measure is unknown")
+	errAggregation    = errors.New("Error! Options aggregation is unknown")
+	errTopK           = errors.New("Error! Options top k should be at least 1")
+	errMaxPatchScale  = errors.New("Error! Options max patch scale should be at least 1")
+	errTextureMeasure = errors.New("Error! Options texture measure is unknown")
+	errIterations     = errors.New("Error! Options PatchMatch iterations should be at least 1")
+)
+
+// Options : Parameters of the reconstruction
+type Options struct {
+	// ROI : Patches whose centers lie outside the region are rejected,
+	// nil means no restriction
+	ROI Region
+	// NumNeighbours : Number of photos kept by the view selection, at least 1
+	NumNeighbours int
+	// MinTriangulationAngle : Neighbours seeing the scene at a smaller
+	// angle, in degrees, are penalized
+	MinTriangulationAngle float64
+	// MaxTriangulationAngle : Neighbours seeing the scene at a larger
+	// angle, in degrees, are discarded
+	MaxTriangulationAngle float64
+	// MinBaselineRatio : Neighbours whose baseline to depth ratio is
+	// smaller are penalized
+	MinBaselineRatio float64
+	// CheckpointPath : File the state of the reconstruction is regularly
+	// saved to, empty means no checkpoints. Clusters are checkpointed as a
+	// whole once they are done
+	CheckpointPath string
+	// CheckpointInterval : Number of iterations between checkpoints, 0 only
+	// saves them at the end of phases
+	CheckpointInterval int
+	// FeaturesCacheDir : Directory the features detected by DetectFeatures
+	// are cached in, empty means no cache
+	FeaturesCacheDir string
+	// PhotoMeasure : Photo-consistency measure used to score patches
+	PhotoMeasure PhotoMeasure
+	// BilateralSigmaColor : Color difference, in intensities between 0
+	// and 1, at which MeasureBilateralNCC weights drop, positive
+	BilateralSigmaColor float64
+	// BilateralSigmaSpace : Distance, in grid samples, at which
+	// MeasureBilateralNCC weights drop, positive
+	BilateralSigmaSpace float64
+	// SSDNoise : Expected noise of intensities for MeasureSSD, positive
+	SSDNoise float64
+	// Aggregation : How the scores of the target photos of a patch are
+	// combined by the optimization, the patch score and MaxScoreDrop
+	Aggregation Aggregation
+	// TruncatedFraction : Fraction of the lowest scores AggregateTruncatedMean
+	// drops, in [0, 1)
+	TruncatedFraction float64
+	// TopK : Number of best scores AggregateTopK averages, at least 1
+	TopK int
+	// OcclusionSigma : Distance of a score to 1 at which the weights of
+	// AggregateOcclusionWeighted drop, positive
+	OcclusionSigma float64
+	// MaxScoreDrop : Photos of a patch scoring less than the aggregation of
+	// the scores of its visible photos minus MaxScoreDrop are dropped from
+	// its target photos, on top of the fixed thresholds. With a robust
+	// aggregation this drops occluded photos. 0 disables the test
+	MaxScoreDrop float64
+	// WindowSize : Number of samples along each side of the grid patches
+	// are compared on, odd and at least 3
+	WindowSize int
+	// AdaptiveScale : Grows the grid of patches in texture poor areas,
+	// otherwise samples are one pixel apart in the reference photo
+	AdaptiveScale bool
+	// MinPatchVariance : Variance of the intensities of the reference
+	// grid, between 0 and 1, the adaptive scale grows the grid to reach
+	MinPatchVariance float64
+	// MaxPatchScale : Largest distance in pixels between samples the
+	// adaptive scale grows the grid to, at least 1
+	MaxPatchScale float64
+	// TextureMeasure : How the texture of the window of a patch in its
+	// reference photo is measured
+	TextureMeasure TextureMeasure
+	// MinTexture : Patches whose reference window has less texture are
+	// rejected before their optimization. Its scale depends on
+	// TextureMeasure, 0 disables the test
+	MinTexture float64
+	// PatchMatchIterations : Number of propagation and refinement passes of
+	// PatchMatchStereo over every pixel, at least 1
+	PatchMatchIterations int
+	// PatchMatchMinScore : Pixels of depth maps scoring less are dropped
+	PatchMatchMinScore float64
+	// PatchMatchMinDepth : Smallest depth of depth maps, the depth range is
+	// estimated from the sparse points and patches unless both
+	// PatchMatchMinDepth and PatchMatchMaxDepth are set
+	PatchMatchMinDepth float64
+	// PatchMatchMaxDepth : Largest depth of depth maps
+	PatchMatchMaxDepth float64
+}
+
+// NewOptions : Creates options with the default values
+func NewOptions() *Options {
+	options := new(Options)
+	options.NumNeighbours = 8
+	options.MinTriangulationAngle = 10
+	options.MaxTriangulationAngle = 60
+	options.MinBaselineRatio = 0.1
+	options.CheckpointInterval = 10
+	options.PhotoMeasure = MeasureNCC
+	options.BilateralSigmaColor = 0.1
+	options.BilateralSigmaSpace = 2
+	options.SSDNoise = 0.05
+	options.Aggregation = AggregateMean
+	options.TruncatedFraction = 0.25
+	options.TopK = 3
+	options.OcclusionSigma = 0.3
+	options.WindowSize = 5
+	options.AdaptiveScale = false
+	options.MinPatchVariance = 0.001
+	options.MaxPatchScale = 4
+	options.TextureMeasure = TextureVariance
+	options.MinTexture = 0
+	options.PatchMatchIterations = 3
+	options.PatchMatchMinScore = 0.5
+	return options
+}
+
+// SetOptions : Sets the options used by the package. Invalid options are
+// rejected with an error and the previous ones are kept
+func SetOptions(newOptions *Options) error {
+	if newOptions == nil {
+		panic("Options shouldn't be nil")
+	}
+	if err := newOptions.validate(); err != nil {
+		return err
+	}
+	options = newOptions
+	// neighbours depend on the view selection options
+	if imgsManager != nil {
+		for _, photo := range imgsManager.Photos {
+			photo.neighbours = nil
+		}
+	}
+	return nil
+}
+
+// validate : Returns an error if an option is out of its range
+func (options *Options) validate() error {
+	if options.NumNeighbours < 1 {
+		return errNumNeighbours
+	}
+	if options.CheckpointInterval < 0 {
+		return errCheckpoint
+	}
+	if options.PhotoMeasure < MeasureNCC || options.PhotoMeasure > MeasureSSD {
+		return errPhotoMeasure
+	}
+	if options.Aggregation < AggregateMean || options.Aggregation > AggregateOcclusionWeighted {
+		return errAggregation
+	}
+	if options.TopK < 1 {
+		return errTopK
+	}
+	if options.WindowSize < 3 || options.WindowSize%2 == 0 {
+		return errWindowSize
+	}
+	if options.MaxPatchScale < 1 {
+		return errMaxPatchScale
+	}
+	if options.TextureMeasure < TextureVariance || options.TextureMeasure > TextureGradientEnergy {
+		return errTextureMeasure
+	}
+	if options.PatchMatchIterations < 1 {
+		return errIterations
+	}
+	if options.BilateralSigmaColor <= 0 || options.BilateralSigmaSpace <= 0 {
+		return errBilateralSigma
+	}
+	if options.SSDNoise <= 0 {
+		return errSSDNoise
+	}
+	if options.TruncatedFraction < 0 || options.TruncatedFraction >= 1 {
+		return errTruncated
+	}
+	if options.OcclusionSigma <= 0 {
+		return errOcclusionSigma
+	}
+	if options.MaxScoreDrop < 0 {
+		return errMaxScoreDrop
+	}
+	return nil
+}
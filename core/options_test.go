@@ -0,0 +1,59 @@
+package core
+
+import "testing"
+
+func TestSetOptionsValidation(t *testing.T) {
+	defer SetOptions(options)
+	tests := []struct {
+		name   string
+		modify func(options *Options)
+		want   error
+	}{
+		{"defaults", func(options *Options) {}, nil},
+		{"even window", func(options *Options) { options.WindowSize = 4 }, errWindowSize},
+		{"small window", func(options *Options) { options.WindowSize = 1 }, errWindowSize},
+		{"color sigma", func(options *Options) { options.BilateralSigmaColor = 0 }, errBilateralSigma},
+		{"space sigma", func(options *Options) { options.BilateralSigmaSpace = -1 }, errBilateralSigma},
+		{"SSD noise", func(options *Options) { options.SSDNoise = 0 }, errSSDNoise},
+		{"negative fraction", func(options *Options) { options.TruncatedFraction = -0.1 }, errTruncated},
+		{"whole fraction", func(options *Options) { options.TruncatedFraction = 1 }, errTruncated},
+		{"occlusion sigma", func(options *Options) { options.OcclusionSigma = 0 }, errOcclusionSigma},
+		{"score drop", func(options *Options) { options.MaxScoreDrop = -0.1 }, errMaxScoreDrop},
+		{"no neighbours", func(options *Options) { options.NumNeighbours = 0 }, errNumNeighbours},
+		{"no intermediate checkpoints", func(options *Options) { options.CheckpointInterval = 0 }, nil},
+		{"checkpoint interval", func(options *Options) { options.CheckpointInterval = -1 },
+			errCheckpoint},
+		{"last photo measure", func(options *Options) { options.PhotoMeasure = MeasureSSD }, nil},
+		{"negative photo measure", func(options *Options) { options.PhotoMeasure = -1 },
+			errPhotoMeasure},
+		{"unknown photo measure", func(options *Options) { options.PhotoMeasure = MeasureSSD + 1 },
+			errPhotoMeasure},
+		{"last aggregation", func(options *Options) { options.Aggregation = AggregateOcclusionWeighted },
+			nil},
+		{"negative aggregation", func(options *Options) { options.Aggregation = -1 }, errAggregation},
+		{"unknown aggregation", func(options *Options) { options.Aggregation = AggregateOcclusionWeighted + 1 },
+			errAggregation},
+		{"top k", func(options *Options) { options.TopK = 0 }, errTopK},
+		{"max patch scale", func(options *Options) { options.MaxPatchScale = 0.5 }, errMaxPatchScale},
+		{"last texture measure", func(options *Options) { options.TextureMeasure = TextureGradientEnergy },
+			nil},
+		{"negative texture measure", func(options *Options) { options.TextureMeasure = -1 },
+			errTextureMeasure},
+		{"unknown texture measure", func(options *Options) { options.TextureMeasure = TextureGradientEnergy + 1 },
+			errTextureMeasure},
+		{"PatchMatch iterations", func(options *Options) { options.PatchMatchIterations = 0 },
+			errIterations},
+	}
+	previous := NewOptions()
+	for _, test := range tests {
+		SetOptions(previous)
+		newOptions := NewOptions()
+		test.modify(newOptions)
+		if err := SetOptions(newOptions); err != test.want {
+			t.Errorf("%v: got error %v, want %v", test.name, err, test.want)
+		}
+		if test.want != nil && options != previous {
+			t.Errorf("%v: invalid options were set", test.name)
+		}
+	}
+}
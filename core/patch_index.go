@@ -0,0 +1,366 @@
+package core
+
+import (
+	"container/heap"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// patches a leaf holds before it is split
+	indexLeafSize = 16
+	// leaves at this depth are never split, so that patches sharing a
+	// center don't split forever
+	indexMaxDepth = 24
+)
+
+// PatchIndex : An octree over the patch centers answering radius, nearest
+// neighbours and frustum queries. The root grows to hold any center, so
+// the extent of the scene needn't be known. Patches shouldn't move while
+// they are indexed, they should be removed then inserted again
+type PatchIndex struct {
+	root *indexNode
+	size int
+}
+
+// indexNode : A cube of the octree, leaves hold entries and inner nodes
+// hold eight children, child i covers the half of axis j above the center
+// if bit j of i is set
+type indexNode struct {
+	center   [3]float64
+	halfSide float64
+	entries  []indexEntry
+	children []*indexNode
+}
+
+type indexEntry struct {
+	position [3]float64
+	patch    *Patch
+}
+
+// NewPatchIndex : Creates new empty index
+func NewPatchIndex() *PatchIndex {
+	return new(PatchIndex)
+}
+
+// Len : Returns the number of indexed patches
+func (index *PatchIndex) Len() int {
+	return index.size
+}
+
+// Insert : Adds the patch to the index. Patches whose center isn't finite,
+// such as points at infinity, are rejected and false is returned
+func (index *PatchIndex) Insert(patch *Patch) bool {
+	position := dehomogenize(patch.Center)
+	if !isFinite3(position) {
+		return false
+	}
+	if index.root == nil {
+		index.root = new(indexNode)
+		index.root.center, index.root.halfSide = position, 1
+	}
+	for !index.root.contains(position) {
+		index.grow(position)
+	}
+	index.root.insert(indexEntry{position, patch}, 0)
+	index.size++
+	return true
+}
+
+// Remove : Removes the patch from the index, returns whether it was there.
+// The patch is looked for at the center it was inserted with, a patch whose
+// center changed since isn't found
+func (index *PatchIndex) Remove(patch *Patch) bool {
+	if index.root == nil {
+		return false
+	}
+	position := dehomogenize(patch.Center)
+	node := index.root
+	for node.children != nil {
+		node = node.children[node.octant(position)]
+	}
+	for i, entry := range node.entries {
+		if entry.patch == patch {
+			last := len(node.entries) - 1
+			node.entries[i] = node.entries[last]
+			node.entries = node.entries[:last]
+			index.size--
+			return true
+		}
+	}
+	return false
+}
+
+// Radius : Returns the patches whose centers are within radius of the
+// homogeneous point
+func (index *PatchIndex) Radius(point *mat.VecDense, radius float64) []*Patch {
+	var result []*Patch
+	if index.root == nil {
+		return result
+	}
+	position := dehomogenize(point)
+	var search func(node *indexNode)
+	search = func(node *indexNode) {
+		if node.distance(position) > radius {
+			return
+		}
+		for _, entry := range node.entries {
+			if distance3(entry.position, position) <= radius {
+				result = append(result, entry.patch)
+			}
+		}
+		for _, child := range node.children {
+			search(child)
+		}
+	}
+	search(index.root)
+	return result
+}
+
+// Nearest : Returns the k patches whose centers are nearest to the
+// homogeneous point, nearest first
+func (index *PatchIndex) Nearest(point *mat.VecDense, k int) []*Patch {
+	if index.root == nil || k <= 0 {
+		return nil
+	}
+	position := dehomogenize(point)
+	// the k nearest so far, farthest on top
+	var best neighbourHeap
+	var search func(node *indexNode)
+	search = func(node *indexNode) {
+		if len(best) == k && node.distance(position) > best[0].distance {
+			return
+		}
+		for _, entry := range node.entries {
+			dist := distance3(entry.position, position)
+			if len(best) < k {
+				heap.Push(&best, neighbour{entry.patch, dist})
+			} else if dist < best[0].distance {
+				best[0] = neighbour{entry.patch, dist}
+				heap.Fix(&best, 0)
+			}
+		}
+		if node.children == nil {
+			return
+		}
+		// visit the nearest children first to shrink the search early
+		order := make([]int, 0, 8)
+		for i := range node.children {
+			order = append(order, i)
+		}
+		for i := 1; i < len(order); i++ {
+			for j := i; j > 0 && node.children[order[j]].distance(position) <
+				node.children[order[j-1]].distance(position); j-- {
+				order[j], order[j-1] = order[j-1], order[j]
+			}
+		}
+		for _, i := range order {
+			search(node.children[i])
+		}
+	}
+	search(index.root)
+
+	result := make([]*Patch, len(best))
+	for i := len(best) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(&best).(neighbour).patch
+	}
+	return result
+}
+
+// InFrustum : Returns the patches whose centers are in front of the camera
+// of the photo and project inside its image
+func (index *PatchIndex) InFrustum(photo *Photo) []*Patch {
+	var result []*Patch
+	if index.root == nil {
+		return result
+	}
+	planes := frustumPlanes(photo)
+	projected := mat.NewVecDense(3, nil)
+	var search func(node *indexNode)
+	search = func(node *indexNode) {
+		if node.outside(planes) {
+			return
+		}
+		for _, entry := range node.entries {
+			if !photo.Cam.InFront(entry.patch.Center) {
+				continue
+			}
+			projected.MulVec(photo.CameraMatrix(), entry.patch.Center)
+			if photo.Contains(projected.AtVec(1)/projected.AtVec(2),
+				projected.AtVec(0)/projected.AtVec(2)) {
+				result = append(result, entry.patch)
+			}
+		}
+		for _, child := range node.children {
+			search(child)
+		}
+	}
+	search(index.root)
+	return result
+}
+
+// grow : Doubles the root towards the position
+func (index *PatchIndex) grow(position [3]float64) {
+	old := index.root
+	root := new(indexNode)
+	root.halfSide = old.halfSide * 2
+	octant := 0
+	for i := 0; i < 3; i++ {
+		// the old root becomes the child on the opposite side of position
+		if position[i] >= old.center[i] {
+			root.center[i] = old.center[i] + old.halfSide
+		} else {
+			root.center[i] = old.center[i] - old.halfSide
+			octant |= 1 << i
+		}
+	}
+	root.split()
+	root.children[octant] = old
+	index.root = root
+}
+
+func (node *indexNode) insert(entry indexEntry, depth int) {
+	for node.children != nil {
+		node = node.children[node.octant(entry.position)]
+		depth++
+	}
+	node.entries = append(node.entries, entry)
+	if len(node.entries) > indexLeafSize && depth < indexMaxDepth {
+		entries := node.entries
+		node.entries = nil
+		node.split()
+		for _, entry := range entries {
+			node.children[node.octant(entry.position)].insert(entry, depth+1)
+		}
+	}
+}
+
+// split : Creates the eight empty children of the node
+func (node *indexNode) split() {
+	node.children = make([]*indexNode, 8)
+	half := node.halfSide / 2
+	for i := range node.children {
+		child := new(indexNode)
+		child.halfSide = half
+		for j := 0; j < 3; j++ {
+			if i&(1<<j) != 0 {
+				child.center[j] = node.center[j] + half
+			} else {
+				child.center[j] = node.center[j] - half
+			}
+		}
+		node.children[i] = child
+	}
+}
+
+func (node *indexNode) octant(position [3]float64) (octant int) {
+	for i := 0; i < 3; i++ {
+		if position[i] >= node.center[i] {
+			octant |= 1 << i
+		}
+	}
+	return
+}
+
+func (node *indexNode) contains(position [3]float64) bool {
+	for i := 0; i < 3; i++ {
+		if math.Abs(position[i]-node.center[i]) > node.halfSide {
+			return false
+		}
+	}
+	return true
+}
+
+// distance : Returns the distance between the position and the cube
+func (node *indexNode) distance(position [3]float64) float64 {
+	var sum float64
+	for i := 0; i < 3; i++ {
+		diff := math.Max(math.Abs(position[i]-node.center[i])-node.halfSide, 0)
+		sum += diff * diff
+	}
+	return math.Sqrt(sum)
+}
+
+// outside : Returns whether the whole cube is on the negative side of one
+// of the planes
+func (node *indexNode) outside(planes [][4]float64) bool {
+	for _, plane := range planes {
+		// the corner furthest along the normal of the plane
+		val := plane[3]
+		for i := 0; i < 3; i++ {
+			val += plane[i] * (node.center[i] + math.Copysign(node.halfSide, plane[i]))
+		}
+		if val < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// frustumPlanes : Returns the planes bounding the space seen by the photo,
+// a point p is inside plane (a, b, c, d) if a*p.x + b*p.y + c*p.z + d >= 0
+func frustumPlanes(photo *Photo) [][4]float64 {
+	proj := photo.CameraMatrix()
+	sign := photo.Cam.scaleSign
+	width, height := float64(photo.Img.Width)-0.5, float64(photo.Img.Height)-0.5
+	planes := make([][4]float64, 5)
+	for i := 0; i < 4; i++ {
+		row0, row1, row2 := proj.At(0, i), proj.At(1, i), proj.At(2, i)
+		planes[0][i] = sign * row2
+		planes[1][i] = sign * (row0 + 0.5*row2)
+		planes[2][i] = sign * (width*row2 - row0)
+		planes[3][i] = sign * (row1 + 0.5*row2)
+		planes[4][i] = sign * (height*row2 - row1)
+	}
+	return planes
+}
+
+// neighbour : A patch and its distance to the query point
+type neighbour struct {
+	patch    *Patch
+	distance float64
+}
+
+// neighbourHeap : A max heap of neighbours on their distance
+type neighbourHeap []neighbour
+
+func (neighbours neighbourHeap) Len() int { return len(neighbours) }
+
+func (neighbours neighbourHeap) Less(i, j int) bool {
+	return neighbours[i].distance > neighbours[j].distance
+}
+
+func (neighbours neighbourHeap) Swap(i, j int) {
+	neighbours[i], neighbours[j] = neighbours[j], neighbours[i]
+}
+
+func (neighbours *neighbourHeap) Push(x interface{}) {
+	*neighbours = append(*neighbours, x.(neighbour))
+}
+
+func (neighbours *neighbourHeap) Pop() interface{} {
+	old := *neighbours
+	last := old[len(old)-1]
+	*neighbours = old[:len(old)-1]
+	return last
+}
+
+func dehomogenize(point *mat.VecDense) [3]float64 {
+	w := point.AtVec(3)
+	return [3]float64{point.AtVec(0) / w, point.AtVec(1) / w, point.AtVec(2) / w}
+}
+
+func isFinite3(a [3]float64) bool {
+	for _, val := range a {
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func distance3(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// newIndexedPatches : Indexes num patches spread over a box much larger
+// than the first root of the index, so that the root grows
+func newIndexedPatches(num int) (*PatchIndex, []*Patch) {
+	rng := rand.New(rand.NewSource(1))
+	index := NewPatchIndex()
+	patches := make([]*Patch, num)
+	for i := range patches {
+		// scaled homogeneous coordinates
+		w := 0.5 + rng.Float64()
+		patches[i] = newTestPatch(w*(rng.Float64()*40-20), w*(rng.Float64()*20-10),
+			w*rng.Float64()*30)
+		patches[i].Center.SetVec(3, w)
+		patches[i].ID = i
+		index.Insert(patches[i])
+	}
+	return index, patches
+}
+
+// sortedIDs : Returns the sorted ids of the patches
+func sortedIDs(patches []*Patch) []int {
+	ids := make([]int, len(patches))
+	for i, patch := range patches {
+		ids[i] = patch.ID
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// bruteForce : Returns the ids of the patches that pass the test, sorted
+func bruteForce(patches []*Patch, keep func(patch *Patch) bool) []int {
+	ids := []int{}
+	for _, patch := range patches {
+		if keep(patch) {
+			ids = append(ids, patch.ID)
+		}
+	}
+	return ids
+}
+
+func TestPatchIndexInsert(t *testing.T) {
+	index, patches := newIndexedPatches(500)
+	if index.Len() != len(patches) {
+		t.Errorf("got %v patches indexed, want %v", index.Len(), len(patches))
+	}
+	if index.root.halfSide <= 1 {
+		t.Errorf("the root didn't grow, its half side is %v", index.root.halfSide)
+	}
+	for _, point := range [][]float64{{1, 0, 0, 0}, {math.NaN(), 0, 0, 1}, {math.Inf(1), 0, 0, 1}} {
+		patch := newTestPatch(0, 0, 0)
+		patch.Center = mat.NewVecDense(4, point)
+		if index.Insert(patch) {
+			t.Errorf("the patch at %v was indexed", point)
+		}
+	}
+	if index.Len() != len(patches) {
+		t.Errorf("got %v patches indexed, want %v", index.Len(), len(patches))
+	}
+}
+
+func TestPatchIndexRadius(t *testing.T) {
+	index, patches := newIndexedPatches(500)
+	for _, query := range [][]float64{{0, 0, 10, 1}, {-30, 8, 2, 2}, {100, 0, 0, 1}} {
+		point := mat.NewVecDense(4, query)
+		for _, radius := range []float64{0, 3, 12, 100} {
+			got := sortedIDs(index.Radius(point, radius))
+			want := bruteForce(patches, func(patch *Patch) bool {
+				return distance3(dehomogenize(patch.Center), dehomogenize(point)) <= radius
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("radius %v around %v: got %v, want %v", radius, query, got, want)
+			}
+		}
+	}
+}
+
+func TestPatchIndexNearest(t *testing.T) {
+	index, patches := newIndexedPatches(500)
+	for _, query := range [][]float64{{0, 0, 10, 1}, {-30, 8, 2, 2}, {100, 0, 0, 1}} {
+		point := mat.NewVecDense(4, query)
+		sorted := append([]*Patch(nil), patches...)
+		distance := func(patch *Patch) float64 {
+			return distance3(dehomogenize(patch.Center), dehomogenize(point))
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return distance(sorted[i]) < distance(sorted[j])
+		})
+		for _, k := range []int{1, 7, 40, 1000} {
+			got := index.Nearest(point, k)
+			want := sorted[:minInt(k, len(sorted))]
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%v nearest to %v: got %v, want %v", k, query, sortedIDs(got),
+					sortedIDs(want))
+			}
+		}
+	}
+	if got := index.Nearest(mat.NewVecDense(4, []float64{0, 0, 0, 1}), 0); len(got) != 0 {
+		t.Errorf("got %v nearest patches, want none", len(got))
+	}
+}
+
+func TestPatchIndexInFrustum(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	// the same camera, the second projection matrix has a negative scale
+	manager := newTestManager(testProjMat(1, 2), testProjMat(-1, 2))
+	index, patches := newIndexedPatches(500)
+	projected := mat.NewVecDense(3, nil)
+	for _, photo := range manager.Photos {
+		got := sortedIDs(index.InFrustum(photo))
+		want := bruteForce(patches, func(patch *Patch) bool {
+			projected.MulVec(photo.CameraMatrix(), patch.Center)
+			return photo.Cam.InFront(patch.Center) &&
+				photo.Contains(projected.AtVec(1)/projected.AtVec(2),
+					projected.AtVec(0)/projected.AtVec(2))
+		})
+		if len(want) == 0 {
+			t.Fatalf("photo %v sees no patch", photo.ID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("photo %v: got %v, want %v", photo.ID, got, want)
+		}
+	}
+}
+
+func TestPatchIndexRemove(t *testing.T) {
+	index, patches := newIndexedPatches(500)
+	for i := 0; i < len(patches); i += 2 {
+		if !index.Remove(patches[i]) {
+			t.Fatalf("patch %v wasn't removed", i)
+		}
+	}
+	if index.Remove(patches[0]) || index.Remove(newTestPatch(0, 0, 10)) {
+		t.Error("removed a patch that isn't indexed")
+	}
+	if index.Len() != len(patches)/2 {
+		t.Errorf("got %v patches indexed, want %v", index.Len(), len(patches)/2)
+	}
+	got := sortedIDs(index.Radius(mat.NewVecDense(4, []float64{0, 0, 0, 1}), 1000))
+	want := bruteForce(patches, func(patch *Patch) bool { return patch.ID%2 == 1 })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v left, want %v", got, want)
+	}
+}
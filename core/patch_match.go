@@ -0,0 +1,453 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"pmvs/image"
+	"runtime"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// number of random perturbations of decreasing size tried per pixel
+	// and iteration
+	patchMatchRefineSteps = 4
+)
+
+var (
+	errNoDepthRange = errors.New("Error! Depth range of the photo is unknown, it sees no sparse points nor patches")
+	errNoViews      = errors.New("Error! Photo has no neighbouring views")
+
+	// neighbours whose hypotheses are propagated, all of the other color of
+	// the checkerboard
+	propagationOffsets = [][2]int{
+		{0, -1}, {0, 1}, {-1, 0}, {1, 0}, {0, -3}, {0, 3}, {-3, 0}, {3, 0},
+	}
+)
+
+// DepthMap : Dense reconstruction of a photo, with the depth along the
+// optical axis, the normal in world coordinates and the score of every
+// pixel. Pixels that are masked or whose score is below PatchMatchMinScore
+// have depth 0
+type DepthMap struct {
+	PhotoID int
+	Depth   *image.CHWImage
+	Normal  *image.CHWImage
+	Score   *image.CHWImage
+}
+
+// Point : Returns the homogeneous point seen at pixel (y, x), nil if the
+// depth of the pixel is unknown
+func (depthMap *DepthMap) Point(y, x int) *mat.VecDense {
+	depth := depthMap.Depth.At(y, x, 0)
+	if depth <= 0 {
+		return nil
+	}
+	return backProject(imgsManager.Photos[depthMap.PhotoID], float64(x), float64(y),
+		float64(depth))
+}
+
+// ComputeDepthMaps : Estimates the depth map of every photo with
+// PatchMatchStereo, the depth map of a photo being at its id. Photos whose
+// depth map can't be estimated, such as photos without views or depth
+// range, are skipped with a warning and get a nil depth map. If ctx is
+// cancelled the depth maps computed so far are returned along with the
+// error of the context
+func ComputeDepthMaps(ctx context.Context) ([]*DepthMap, error) {
+	const phase = "depth maps"
+	start := time.Now()
+	numPhotos := len(imgsManager.Photos)
+	observer.PhaseStarted(phase, numPhotos)
+	depthMaps := make([]*DepthMap, 0, numPhotos)
+	for id := range imgsManager.Photos {
+		photoStart := time.Now()
+		depthMap, err := PatchMatchStereo(ctx, id)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			observer.PhaseEnded(phase, 0, time.Since(start))
+			return depthMaps, ctxErr
+		}
+		if err != nil {
+			observer.Warning("depth map skipped", "photo", id, "error", err)
+		} else {
+			observer.PhotoDone(phase, id, 0, time.Since(photoStart))
+		}
+		depthMaps = append(depthMaps, depthMap)
+		observer.Progress(phase, id+1, numPhotos)
+	}
+	observer.PhaseEnded(phase, 0, time.Since(start))
+	return depthMaps, nil
+}
+
+// PatchMatchStereo : Estimates the depth and normal of every pixel of the
+// photo by PatchMatch stereo. Every pixel holds a plane, scored like patches
+// over the photos chosen by the view selection. Planes are initialized at
+// random in the depth range of the photo, then improved by propagating the
+// planes of neighbouring pixels and trying random perturbations of
+// decreasing size, pixels being updated in a checkerboard pattern so that
+// half of them can be updated in parallel. The depth range is
+// PatchMatchMinDepth to PatchMatchMaxDepth if both are set, otherwise the
+// range of the sparse points and patches seen by the photo
+func PatchMatchStereo(ctx context.Context, photoID int) (*DepthMap, error) {
+	matcher, err := newPatchMatcher(photoID)
+	if err != nil {
+		return nil, err
+	}
+	matcher.forEachPixel(func(y, x int, rng *rand.Rand, buf *patchMatchBuffers) {
+		matcher.initialize(y, x, rng, buf)
+	}, 0, -1)
+	for iteration := 0; iteration < options.PatchMatchIterations; iteration++ {
+		for color := 0; color < 2; color++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			matcher.forEachPixel(func(y, x int, rng *rand.Rand, buf *patchMatchBuffers) {
+				matcher.update(y, x, rng, buf)
+			}, 2*iteration+color+1, color)
+		}
+	}
+	return matcher.depthMap(), nil
+}
+
+// patchMatcher : State of the PatchMatch stereo of one photo, the plane of
+// pixel (y, x) being at index y * width + x
+type patchMatcher struct {
+	photo    *Photo
+	views    []*Photo
+	width    int
+	height   int
+	center   [3]float64
+	minDepth float64
+	maxDepth float64
+	// maps (x, y, 1) to the ray of the pixel, whose component along the
+	// optical axis is 1, R^T K^-1
+	rayMat [3][3]float64
+	// projection matrices of the views
+	projs      [][3][4]float64
+	projSigns  []float64
+	depths     []float64
+	normals    [][3]float64
+	scores     []float64
+	masked     []bool
+	windowSize int
+}
+
+// patchMatchBuffers : Buffers of a worker, reused for every score
+type patchMatchBuffers struct {
+	refCell   []float32
+	viewCells [][]float32
+	visible   []bool
+	scores    []float64
+}
+
+func newPatchMatcher(photoID int) (matcher *patchMatcher, err error) {
+	photo := imgsManager.Photos[photoID]
+	matcher = new(patchMatcher)
+	matcher.photo = photo
+	matcher.width, matcher.height = photo.Img.Width, photo.Img.Height
+	matcher.windowSize = options.WindowSize
+	for _, id := range getRelevantImages(photoID) {
+		matcher.views = append(matcher.views, imgsManager.Photos[id])
+	}
+	if len(matcher.views) == 0 {
+		return nil, errNoViews
+	}
+	matcher.minDepth, matcher.maxDepth = options.PatchMatchMinDepth, options.PatchMatchMaxDepth
+	if matcher.minDepth <= 0 || matcher.maxDepth <= matcher.minDepth {
+		var ok bool
+		if matcher.minDepth, matcher.maxDepth, ok = depthRange(photo); !ok {
+			return nil, errNoDepthRange
+		}
+	}
+
+	var kInv, rayMat mat.Dense
+	if err = kInv.Inverse(photo.Cam.K); err != nil {
+		return nil, err
+	}
+	rayMat.Mul(photo.Cam.R.T(), &kInv)
+	for i := 0; i < 3; i++ {
+		matcher.center[i] = photo.OpticalCenter().AtVec(i) / photo.OpticalCenter().AtVec(3)
+		for j := 0; j < 3; j++ {
+			matcher.rayMat[i][j] = rayMat.At(i, j)
+		}
+	}
+	for _, view := range matcher.views {
+		var proj [3][4]float64
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 4; j++ {
+				proj[i][j] = view.CameraMatrix().At(i, j)
+			}
+		}
+		matcher.projs = append(matcher.projs, proj)
+		matcher.projSigns = append(matcher.projSigns, view.Cam.scaleSign)
+	}
+
+	numPixels := matcher.width * matcher.height
+	matcher.depths = make([]float64, numPixels)
+	matcher.normals = make([][3]float64, numPixels)
+	matcher.scores = make([]float64, numPixels)
+	matcher.masked = make([]bool, numPixels)
+	if photo.Mask != nil {
+		for y := 0; y < matcher.height; y++ {
+			for x := 0; x < matcher.width; x++ {
+				matcher.masked[y*matcher.width+x] = photo.Mask.At(y, x, 0) == 0
+			}
+		}
+	}
+	return
+}
+
+// depthRange : Returns the range of the depths of the sparse points and
+// patches seen by the photo, widened by a margin
+func depthRange(photo *Photo) (minDepth, maxDepth float64, ok bool) {
+	minDepth, maxDepth = math.Inf(1), math.Inf(-1)
+	addPoint := func(point *mat.VecDense) {
+		if depth := pointDepth(photo, point); depth > 0 {
+			minDepth, maxDepth = math.Min(minDepth, depth), math.Max(maxDepth, depth)
+		}
+	}
+	for _, point := range imgsManager.SparsePoints {
+		if containsInt(point.Photos, photo.ID) {
+			addPoint(point.Position)
+		}
+	}
+	if imgsManager.PatchIndex != nil {
+		for _, patch := range imgsManager.PatchIndex.InFrustum(photo) {
+			addPoint(patch.Center)
+		}
+	}
+	if minDepth > maxDepth {
+		return 0, 0, false
+	}
+	margin := 0.1*(maxDepth-minDepth) + 0.05*maxDepth
+	return math.Max(minDepth-margin, 0.5*minDepth), maxDepth + margin, true
+}
+
+// forEachPixel : Calls update on every unmasked pixel of the color of the
+// checkerboard, every pixel if color is negative, rows being shared among
+// workers. The random numbers of a row only depend on the photo, the pass
+// and the row
+func (matcher *patchMatcher) forEachPixel(update func(y, x int, rng *rand.Rand,
+	buf *patchMatchBuffers), pass, color int) {
+
+	numWorkers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for worker := 0; worker < numWorkers; worker++ {
+		go func(worker int) {
+			defer wg.Done()
+			buf := matcher.newBuffers()
+			for y := worker; y < matcher.height; y += numWorkers {
+				seed := (int64(matcher.photo.ID)*1000+int64(pass))*100000 + int64(y)
+				rng := rand.New(rand.NewSource(seed))
+				start, step := 0, 1
+				if color >= 0 {
+					start, step = (y+color)%2, 2
+				}
+				for x := start; x < matcher.width; x += step {
+					if !matcher.masked[y*matcher.width+x] {
+						update(y, x, rng, buf)
+					}
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
+
+func (matcher *patchMatcher) newBuffers() *patchMatchBuffers {
+	cellLength := matcher.windowSize * matcher.windowSize * 3
+	buf := new(patchMatchBuffers)
+	buf.refCell = make([]float32, cellLength)
+	buf.viewCells = make([][]float32, len(matcher.views))
+	for i := range buf.viewCells {
+		buf.viewCells[i] = make([]float32, cellLength)
+	}
+	buf.visible = make([]bool, len(matcher.views))
+	buf.scores = make([]float64, 0, len(matcher.views))
+	return buf
+}
+
+// initialize : Gives the pixel a random plane
+func (matcher *patchMatcher) initialize(y, x int, rng *rand.Rand, buf *patchMatchBuffers) {
+	index := y*matcher.width + x
+	ray := matcher.ray(float64(x), float64(y))
+	depth := matcher.minDepth + rng.Float64()*(matcher.maxDepth-matcher.minDepth)
+	normal := randomNormal(rng, ray)
+	matcher.depths[index], matcher.normals[index] = depth, normal
+	matcher.scores[index] = matcher.score(y, x, depth, normal, buf)
+}
+
+// update : Replaces the plane of the pixel by the planes of its neighbours
+// or random perturbations of it if they score better
+func (matcher *patchMatcher) update(y, x int, rng *rand.Rand, buf *patchMatchBuffers) {
+	index := y*matcher.width + x
+	ray := matcher.ray(float64(x), float64(y))
+	try := func(depth float64, normal [3]float64) {
+		if depth < matcher.minDepth || depth > matcher.maxDepth || dot3(normal, ray) >= 0 {
+			return
+		}
+		if score := matcher.score(y, x, depth, normal, buf); score > matcher.scores[index] {
+			matcher.depths[index], matcher.normals[index] = depth, normal
+			matcher.scores[index] = score
+		}
+	}
+
+	for _, offset := range propagationOffsets {
+		y2, x2 := y+offset[0], x+offset[1]
+		if y2 < 0 || x2 < 0 || y2 >= matcher.height || x2 >= matcher.width {
+			continue
+		}
+		index2 := y2*matcher.width + x2
+		if matcher.masked[index2] {
+			continue
+		}
+		// depth at which the ray of the pixel meets the plane of the neighbour
+		normal := matcher.normals[index2]
+		ray2 := matcher.ray(float64(x2), float64(y2))
+		denom := dot3(normal, ray)
+		if denom >= 0 {
+			continue
+		}
+		try(matcher.depths[index2]*dot3(normal, ray2)/denom, normal)
+	}
+
+	try(matcher.minDepth+rng.Float64()*(matcher.maxDepth-matcher.minDepth),
+		randomNormal(rng, ray))
+	scale := 0.5
+	for i := 0; i < patchMatchRefineSteps; i++ {
+		depth, normal := matcher.depths[index], matcher.normals[index]
+		try(depth+(2*rng.Float64()-1)*scale*(matcher.maxDepth-matcher.minDepth), normal)
+		depth, normal = matcher.depths[index], matcher.normals[index]
+		try(depth, perturbNormal(rng, normal, scale))
+		scale /= 2
+	}
+}
+
+// score : Returns the aggregated score of the photos for the plane at the
+// given depth with the given normal at pixel (y, x). The grid of the plane
+// is the window of the pixel in the photo, projected to the views through
+// the plane
+func (matcher *patchMatcher) score(y, x int, depth float64, normal [3]float64,
+	buf *patchMatchBuffers) float64 {
+
+	ray := matcher.ray(float64(x), float64(y))
+	// distance of the plane to the optical center along its normal
+	planeDist := depth * dot3(normal, ray)
+	for i := range buf.visible {
+		buf.visible[i] = true
+	}
+	radius := matcher.windowSize / 2
+	resIndex := 0
+	for j := -radius; j <= radius; j++ {
+		for i := -radius; i <= radius; i++ {
+			sampleX, sampleY := float64(x+i), float64(y+j)
+			buf.refCell[resIndex], buf.refCell[resIndex+1], buf.refCell[resIndex+2] =
+				matcher.photo.At(sampleY, sampleX)
+			sampleRay := matcher.ray(sampleX, sampleY)
+			denom := dot3(normal, sampleRay)
+			if denom >= 0 {
+				// the window sees the plane edge on
+				return -1
+			}
+			sampleDepth := planeDist / denom
+			var point [3]float64
+			for k := 0; k < 3; k++ {
+				point[k] = matcher.center[k] + sampleDepth*sampleRay[k]
+			}
+			for v, proj := range matcher.projs {
+				var projected [3]float64
+				for k := 0; k < 3; k++ {
+					projected[k] = proj[k][0]*point[0] + proj[k][1]*point[1] +
+						proj[k][2]*point[2] + proj[k][3]
+				}
+				if projected[2]*matcher.projSigns[v] <= 0 {
+					buf.visible[v] = false
+					continue
+				}
+				cell := buf.viewCells[v]
+				cell[resIndex], cell[resIndex+1], cell[resIndex+2] =
+					matcher.views[v].At(projected[1]/projected[2], projected[0]/projected[2])
+			}
+			resIndex += 3
+		}
+	}
+
+	buf.scores = buf.scores[:0]
+	for v, cell := range buf.viewCells {
+		if buf.visible[v] {
+			buf.scores = append(buf.scores, photoScore(buf.refCell, cell))
+		} else {
+			buf.scores = append(buf.scores, -1)
+		}
+	}
+	return aggregateScores(buf.scores)
+}
+
+// ray : Returns the ray of the pixel, whose component along the optical
+// axis is 1
+func (matcher *patchMatcher) ray(x, y float64) (ray [3]float64) {
+	for i := 0; i < 3; i++ {
+		ray[i] = matcher.rayMat[i][0]*x + matcher.rayMat[i][1]*y + matcher.rayMat[i][2]
+	}
+	return
+}
+
+// depthMap : Returns the planes as images, dropping those scoring below
+// PatchMatchMinScore
+func (matcher *patchMatcher) depthMap() *DepthMap {
+	depthMap := new(DepthMap)
+	depthMap.PhotoID = matcher.photo.ID
+	depthMap.Depth = image.NewImage(matcher.height, matcher.width, 1)
+	depthMap.Normal = image.NewImage(matcher.height, matcher.width, 3)
+	depthMap.Score = image.NewImage(matcher.height, matcher.width, 1)
+	for y := 0; y < matcher.height; y++ {
+		for x := 0; x < matcher.width; x++ {
+			index := y*matcher.width + x
+			if matcher.masked[index] || matcher.scores[index] < options.PatchMatchMinScore {
+				continue
+			}
+			depthMap.Depth.Set(y, x, 0, float32(matcher.depths[index]))
+			depthMap.Score.Set(y, x, 0, float32(matcher.scores[index]))
+			for c := 0; c < 3; c++ {
+				depthMap.Normal.Set(y, x, c, float32(matcher.normals[index][c]))
+			}
+		}
+	}
+	return depthMap
+}
+
+// randomNormal : Returns a random unit vector facing the ray
+func randomNormal(rng *rand.Rand, ray [3]float64) [3]float64 {
+	for {
+		normal := [3]float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}
+		if norm := math.Sqrt(dot3(normal, normal)); norm > 1e-9 {
+			if dot3(normal, ray) > 0 {
+				norm = -norm
+			}
+			return [3]float64{normal[0] / norm, normal[1] / norm, normal[2] / norm}
+		}
+	}
+}
+
+// perturbNormal : Returns the unit vector of the normal moved in a random
+// direction by about scale
+func perturbNormal(rng *rand.Rand, normal [3]float64, scale float64) [3]float64 {
+	var result [3]float64
+	for i := range result {
+		result[i] = normal[i] + scale*rng.NormFloat64()
+	}
+	norm := math.Sqrt(dot3(result, result))
+	if norm < 1e-9 {
+		return normal
+	}
+	return [3]float64{result[0] / norm, result[1] / norm, result[2] / norm}
+}
+
+func dot3(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// phaseObserver : Counts the photos done, the phases ended and the warnings
+type phaseObserver struct {
+	BaseObserver
+	photosDone int
+	ended      int
+	warnings   int
+}
+
+func (observer *phaseObserver) PhotoDone(phase string, photoID, patches int,
+	elapsed time.Duration) {
+	observer.photosDone++
+}
+
+func (observer *phaseObserver) PhaseEnded(phase string, patches int, elapsed time.Duration) {
+	observer.ended++
+}
+
+func (observer *phaseObserver) Warning(msg string, args ...any) {
+	observer.warnings++
+}
+
+func TestComputeDepthMapsSkipsPhotos(t *testing.T) {
+	defer SetObserver(observer)
+	defer useImagesManager(imgsManager)
+	// no sparse points nor patches, so no photo has a depth range
+	newTestManager(testProjMat(1, 0), testProjMat(1, -1))
+
+	recorder := new(phaseObserver)
+	SetObserver(recorder)
+	depthMaps, err := ComputeDepthMaps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depthMaps) != 2 || depthMaps[0] != nil || depthMaps[1] != nil {
+		t.Errorf("got depth maps %v, want 2 nil ones", depthMaps)
+	}
+	if recorder.warnings != 2 || recorder.ended != 1 || recorder.photosDone != 0 {
+		t.Errorf("got %v warnings, %v phases ended and %v photos done, want 2, 1 and 0",
+			recorder.warnings, recorder.ended, recorder.photosDone)
+	}
+
+	recorder = new(phaseObserver)
+	SetObserver(recorder)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	depthMaps, err = ComputeDepthMaps(ctx)
+	if err != context.Canceled || len(depthMaps) != 0 {
+		t.Errorf("got %v depth maps and error %v, want none and %v",
+			len(depthMaps), err, context.Canceled)
+	}
+	if recorder.ended != 1 {
+		t.Errorf("got %v phases ended, want 1", recorder.ended)
+	}
+}
+
+func TestPatchMatchStereoPlane(t *testing.T) {
+	defer SetOptions(options)
+	defer useImagesManager(imgsManager)
+	planeNormal := normalize3([3]float64{1, 0.3, 0.2})
+	cameras := make([]sceneCamera, 3)
+	for i := range cameras {
+		azimuth := 0.25 * float64(i-1)
+		cameras[i] = lookAtOrigin([3]float64{4 * math.Cos(azimuth), 4 * math.Sin(azimuth), 0.3})
+	}
+	newSceneManager(cameras, func(origin, direction [3]float64) ([3]float64, bool) {
+		return hitPlane(origin, direction, planeNormal)
+	})
+	photo := imgsManager.Photos[1]
+	// the side photos are the views of the middle one, whatever the view
+	// selection
+	photo.neighbours = []int{0, 2}
+	newOptions := NewOptions()
+	newOptions.PatchMatchMinDepth, newOptions.PatchMatchMaxDepth = 2, 8
+	newOptions.WindowSize = 9
+	if err := SetOptions(newOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	// the plane scores better than planes at other depths or orientations
+	cam := cameras[1]
+	trueDepth := func(y, x int) float64 {
+		return -dot3(planeNormal, cam.center) / dot3(planeNormal, cam.ray(float64(y), float64(x)))
+	}
+	matcher, err := newPatchMatcher(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := matcher.newBuffers()
+	y, x := sceneHeight/2, sceneWidth/2
+	best := matcher.score(y, x, trueDepth(y, x), planeNormal, buf)
+	if best < 0.9 {
+		t.Errorf("the plane scores %v", best)
+	}
+	tilted := normalize3([3]float64{1, 1, 0})
+	for _, wrong := range []float64{0.9 * trueDepth(y, x), 1.1 * trueDepth(y, x)} {
+		if score := matcher.score(y, x, wrong, planeNormal, buf); score >= best {
+			t.Errorf("depth %v scores %v, the plane %v", wrong, score, best)
+		}
+	}
+	if score := matcher.score(y, x, trueDepth(y, x), tilted, buf); score >= best {
+		t.Errorf("normal %v scores %v, the plane %v", tilted, score, best)
+	}
+
+	// propagation and refinement recover the plane away from the borders, up
+	// to the sampling of the photos at the nearest pixel
+	depthMap, err := PatchMatchStereo(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	margin := options.WindowSize
+	var total, goodDepths, goodNormals int
+	for y := margin; y < sceneHeight-margin; y++ {
+		for x := margin; x < sceneWidth-margin; x++ {
+			total++
+			depth := float64(depthMap.Depth.At(y, x, 0))
+			if math.Abs(depth-trueDepth(y, x)) < 0.025*trueDepth(y, x) {
+				goodDepths++
+			}
+			var normal [3]float64
+			for c := 0; c < 3; c++ {
+				normal[c] = float64(depthMap.Normal.At(y, x, c))
+			}
+			if dot3(normal, planeNormal) > math.Cos(20*math.Pi/180) {
+				goodNormals++
+			}
+		}
+	}
+	if goodDepths < total*9/10 || goodNormals < total*9/10 {
+		t.Errorf("recovered the depth at %v pixels and the normal at %v out of %v",
+			goodDepths, goodNormals, total)
+	}
+
+	// points of the depth map project back to their pixel at their depth
+	projected := mat.NewVecDense(3, nil)
+	for y := 0; y < sceneHeight; y++ {
+		for x := 0; x < sceneWidth; x++ {
+			depth := float64(depthMap.Depth.At(y, x, 0))
+			point := depthMap.Point(y, x)
+			if depth == 0 {
+				if point != nil {
+					t.Fatalf("pixel (%v, %v) has no depth but the point %v", y, x, point)
+				}
+				continue
+			}
+			projected.MulVec(photo.CameraMatrix(), point)
+			px, py := projected.AtVec(0)/projected.AtVec(2), projected.AtVec(1)/projected.AtVec(2)
+			if math.Abs(px-float64(x)) > 1e-6 || math.Abs(py-float64(y)) > 1e-6 ||
+				math.Abs(pointDepth(photo, point)-depth) > 1e-6*depth {
+				t.Fatalf("pixel (%v, %v) at depth %v gives the point %v seen at (%v, %v) "+
+					"at depth %v", y, x, depth, point, py, px, pointDepth(photo, point))
+			}
+		}
+	}
+}
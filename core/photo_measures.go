@@ -0,0 +1,227 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// minSSDGain : MeasureSSD rejects gains further from 1 than this factor, a
+// window matched to a much flatter or much more contrasted one is more
+// likely a wrong match than a change of exposure
+const minSSDGain = 0.5
+
+// bilateralWeights : Buffers of the weights of bilateralNCC, reused across
+// calls that may run concurrently
+var bilateralWeights = sync.Pool{
+	New: func() any { return new([]float64) },
+}
+
+// PhotoMeasure : How the photo-consistency of two projections of a patch is
+// measured. Every measure gives a score between -1 and 1, higher is more
+// consistent, so that the same thresholds apply to all of them
+type PhotoMeasure int
+
+const (
+	// MeasureNCC : Normalized cross correlation over all the channels
+	MeasureNCC PhotoMeasure = iota
+	// MeasureBilateralNCC : Normalized cross correlation whose samples are
+	// weighted by their distance to the center of the patch, in the grid and
+	// in color in the reference photo, so that samples across depth or
+	// color edges count less
+	MeasureBilateralNCC
+	// MeasureCensus : Compares the census transforms, the signs of the
+	// differences of every sample with the center of the patch, and scores
+	// 1 - 2 * the fraction of differing signs. It only depends on the order
+	// of intensities, so it is robust to any monotonic change of brightness
+	MeasureCensus
+	// MeasureSSD : Sum of squared differences once the gain and bias
+	// between the projections are compensated by least squares, scored
+	// 2 * exp(-rms^2 / (2 * SSDNoise^2)) - 1. Unlike NCC it doesn't blow up
+	// the noise of texture poor patches. Projections whose deviation is
+	// below SSDNoise score 0, as they can't be told apart, and gains
+	// further from 1 than a factor 2 score -1
+	MeasureSSD
+)
+
+// photoScore : Returns the photo-consistency of two projections of a patch
+// sampled by projectGrid, cell1 being the one in the reference photo
+func photoScore(cell1, cell2 []float32) float64 {
+	switch options.PhotoMeasure {
+	case MeasureBilateralNCC:
+		return bilateralNCC(cell1, cell2, options.BilateralSigmaColor,
+			options.BilateralSigmaSpace)
+	case MeasureCensus:
+		return census(cell1, cell2)
+	case MeasureSSD:
+		return compensatedSSD(cell1, cell2, options.SSDNoise)
+	}
+	return ncc(cell1, cell2)
+}
+
+// bilateralNCC : Weighted NCC, the weight of a sample decreases with its
+// distance in the grid and its color difference in cell1 to the center
+func bilateralNCC(cell1, cell2 []float32, sigmaColor, sigmaSpace float64) float64 {
+	numSamples := len(cell1) / 3
+	gridSize := int(math.Sqrt(float64(numSamples)) + 0.5)
+	center := numSamples / 2
+	buffer := bilateralWeights.Get().(*[]float64)
+	defer bilateralWeights.Put(buffer)
+	if cap(*buffer) < numSamples {
+		*buffer = make([]float64, numSamples)
+	}
+	weights := (*buffer)[:numSamples]
+	var totWeight float64
+	for i := 0; i < numSamples; i++ {
+		dy := float64(i/gridSize - center/gridSize)
+		dx := float64(i%gridSize - center%gridSize)
+		var colorDist float64
+		for c := 0; c < 3; c++ {
+			diff := float64(cell1[3*i+c] - cell1[3*center+c])
+			colorDist += diff * diff
+		}
+		weights[i] = math.Exp(-(dx*dx+dy*dy)/(2*sigmaSpace*sigmaSpace) -
+			colorDist/(2*sigmaColor*sigmaColor))
+		totWeight += weights[i]
+	}
+
+	var mean1, mean2 float64
+	for i, val := range cell1 {
+		mean1 += weights[i/3] * float64(val)
+		mean2 += weights[i/3] * float64(cell2[i])
+	}
+	mean1 /= 3 * totWeight
+	mean2 /= 3 * totWeight
+
+	var std1, std2, product float64
+	for i, val := range cell1 {
+		diff1 := float64(val) - mean1
+		diff2 := float64(cell2[i]) - mean2
+		product += weights[i/3] * diff1 * diff2
+		std1 += weights[i/3] * diff1 * diff1
+		std2 += weights[i/3] * diff2 * diff2
+	}
+	stds := std1 * std2
+	if stds == 0 {
+		return 0
+	}
+	return product / math.Sqrt(stds)
+}
+
+// census : Compares the census transforms of the cells around their center
+// sample, channel by channel
+func census(cell1, cell2 []float32) float64 {
+	center := len(cell1) / 3 / 2
+	bits, differing := 0, 0
+	for i := range cell1 {
+		c := i % 3
+		if i/3 == center {
+			continue
+		}
+		bit1 := cell1[i] > cell1[3*center+c]
+		bit2 := cell2[i] > cell2[3*center+c]
+		if bit1 != bit2 {
+			differing++
+		}
+		bits++
+	}
+	if bits == 0 {
+		return 0
+	}
+	return 1 - 2*float64(differing)/float64(bits)
+}
+
+// compensatedSSD : Fits cell2 = gain * cell1 + bias and scores the residual.
+// A cell flatter than the noise gives no information, and a gain far from 1
+// means the cells don't match
+func compensatedSSD(cell1, cell2 []float32, noise float64) float64 {
+	length := float64(len(cell1))
+	var mean1, mean2 float64
+	for i, val := range cell1 {
+		mean1 += float64(val)
+		mean2 += float64(cell2[i])
+	}
+	mean1 /= length
+	mean2 /= length
+
+	var var1, var2, covar float64
+	for i, val := range cell1 {
+		diff1 := float64(val) - mean1
+		diff2 := float64(cell2[i]) - mean2
+		var1 += diff1 * diff1
+		var2 += diff2 * diff2
+		covar += diff1 * diff2
+	}
+	noiseFloor := length * noise * noise
+	if var1 < noiseFloor || var2 < noiseFloor {
+		return 0
+	}
+	gain := covar / var1
+	if gain < minSSDGain || gain > 1/minSSDGain {
+		return -1
+	}
+	var ssd float64
+	for i, val := range cell1 {
+		diff := gain*(float64(val)-mean1) - (float64(cell2[i]) - mean2)
+		ssd += diff * diff
+	}
+	return 2*math.Exp(-ssd/length/(2*noise*noise)) - 1
+}
+
+// Aggregation : How the scores of the target photos of a patch are combined
+// into one score. Robust aggregations keep a few occluded or specular
+// photos from dragging the score down and the patch off the surface
+type Aggregation int
+
+const (
+	// AggregateMean : Mean of the scores
+	AggregateMean Aggregation = iota
+	// AggregateTruncatedMean : Mean of the scores once the TruncatedFraction
+	// lowest ones are dropped
+	AggregateTruncatedMean
+	// AggregateTopK : Mean of the TopK highest scores
+	AggregateTopK
+	// AggregateOcclusionWeighted : Weighted mean of the scores, the weight of
+	// a score being exp(-(1 - score)^2 / (2 * OcclusionSigma^2)), so that
+	// photos disagreeing with the reference photo, likely occluded, count
+	// less
+	AggregateOcclusionWeighted
+)
+
+// aggregateScores : Combines the scores of the target photos of a patch,
+// the scores are reordered
+func aggregateScores(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	switch options.Aggregation {
+	case AggregateTruncatedMean:
+		sort.Float64s(scores)
+		dropped := int(options.TruncatedFraction * float64(len(scores)))
+		return mean(scores[minInt(dropped, len(scores)-1):])
+	case AggregateTopK:
+		sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+		return mean(scores[:minInt(options.TopK, len(scores))])
+	case AggregateOcclusionWeighted:
+		var totWeight, totScore float64
+		for _, score := range scores {
+			weight := math.Exp(-(1 - score) * (1 - score) /
+				(2 * options.OcclusionSigma * options.OcclusionSigma))
+			totWeight += weight
+			totScore += weight * score
+		}
+		if totWeight == 0 {
+			return mean(scores)
+		}
+		return totScore / totWeight
+	}
+	return mean(scores)
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, val := range values {
+		sum += val
+	}
+	return sum / float64(len(values))
+}
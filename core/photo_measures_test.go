@@ -0,0 +1,117 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// measureScene : Pairs of cells sampled from a smooth color texture. The
+// matching pairs see the same window with a change of gain and bias and
+// some noise, the other pairs see windows a few pixels apart
+type measureScene struct {
+	matching   [][2][]float32
+	mismatched [][2][]float32
+}
+
+func textureAt(x, y float64) [3]float32 {
+	v := math.Sin(0.7*x)*math.Cos(0.5*y) + math.Sin(0.9*y+0.3*x)
+	u := math.Cos(1.1*y+0.2*x) * math.Sin(0.6*x)
+	return [3]float32{float32(0.5 + 0.25*v), float32(0.5 + 0.4*u),
+		float32(0.5 + 0.2*(v-u))}
+}
+
+func sampleCell(x, y float64, gridSize int, gain, bias float64, noise float64,
+	rng *rand.Rand) []float32 {
+
+	cell := make([]float32, 0, gridSize*gridSize*3)
+	for i := 0; i < gridSize; i++ {
+		for j := 0; j < gridSize; j++ {
+			color := textureAt(x+float64(j), y+float64(i))
+			for _, val := range color {
+				sample := gain*float64(val) + bias + noise*rng.NormFloat64()
+				cell = append(cell, float32(sample))
+			}
+		}
+	}
+	return cell
+}
+
+func newMeasureScene(numPairs, gridSize int) *measureScene {
+	rng := rand.New(rand.NewSource(1))
+	scene := new(measureScene)
+	for i := 0; i < numPairs; i++ {
+		x, y := 100*rng.Float64(), 100*rng.Float64()
+		gain, bias := 0.8+0.4*rng.Float64(), 0.1*rng.Float64()-0.05
+		ref := sampleCell(x, y, gridSize, 1, 0, 0.01, rng)
+		scene.matching = append(scene.matching, [2][]float32{
+			ref, sampleCell(x, y, gridSize, gain, bias, 0.01, rng),
+		})
+		shift := 3 + 5*rng.Float64()
+		scene.mismatched = append(scene.mismatched, [2][]float32{
+			ref, sampleCell(x+shift, y, gridSize, gain, bias, 0.01, rng),
+		})
+	}
+	return scene
+}
+
+func BenchmarkPhotoMeasures(b *testing.B) {
+	defer SetOptions(options)
+	scene := newMeasureScene(256, NewOptions().WindowSize)
+	measures := []struct {
+		name    string
+		measure PhotoMeasure
+	}{
+		{"NCC", MeasureNCC},
+		{"BilateralNCC", MeasureBilateralNCC},
+		{"Census", MeasureCensus},
+		{"SSD", MeasureSSD},
+	}
+	for _, measure := range measures {
+		newOptions := NewOptions()
+		newOptions.PhotoMeasure = measure.measure
+		if err := SetOptions(newOptions); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(measure.name, func(b *testing.B) {
+			var matchScore, mismatchScore float64
+			for i := 0; i < b.N; i++ {
+				pair := scene.matching[i%len(scene.matching)]
+				matchScore += photoScore(pair[0], pair[1])
+				pair = scene.mismatched[i%len(scene.mismatched)]
+				mismatchScore += photoScore(pair[0], pair[1])
+			}
+			// how far apart the measure puts matching and mismatched pairs
+			b.ReportMetric(matchScore/float64(b.N), "match")
+			b.ReportMetric(mismatchScore/float64(b.N), "mismatch")
+		})
+	}
+}
+
+func TestCompensatedSSD(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ref := sampleCell(10, 20, 5, 1, 0, 0, rng)
+	flat := make([]float32, len(ref))
+	for i := range flat {
+		flat[i] = 0.5
+	}
+	tests := []struct {
+		name  string
+		cell2 []float32
+		want  func(score float64) bool
+	}{
+		{"same window", sampleCell(10, 20, 5, 1.1, 0.05, 0, rng),
+			func(score float64) bool { return score > 0.99 }},
+		{"flat window", flat,
+			func(score float64) bool { return score == 0 }},
+		{"inverted window", sampleCell(10, 20, 5, -1, 1, 0, rng),
+			func(score float64) bool { return score == -1 }},
+		{"much flatter window", sampleCell(10, 20, 5, 0.3, 0.35, 0, rng),
+			func(score float64) bool { return score == -1 }},
+	}
+	for _, test := range tests {
+		if score := compensatedSSD(ref, test.cell2, 0.05); !test.want(score) {
+			t.Errorf("%v: unexpected score %v", test.name, score)
+		}
+	}
+}
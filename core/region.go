@@ -0,0 +1,108 @@
+package core
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Region : A region of interest in world coordinates
+type Region interface {
+	// Contains : Returns whether the homogeneous point lies in the region
+	Contains(point *mat.VecDense) bool
+}
+
+// AxisAlignedBox : A box whose faces are parallel to the world axes
+type AxisAlignedBox struct {
+	Min [3]float64
+	Max [3]float64
+}
+
+// OrientedBox : A box centered at Center whose i-th face normal is Axes[i]
+// and whose half extent along it is HalfSizes[i]
+type OrientedBox struct {
+	Center    [3]float64
+	Axes      [3][3]float64
+	HalfSizes [3]float64
+}
+
+// ConvexPolyhedron : The intersection of half spaces, a point p is inside
+// plane (a, b, c, d) if a*p.x + b*p.y + c*p.z + d <= 0
+type ConvexPolyhedron struct {
+	Planes [][4]float64
+}
+
+// NewAxisAlignedBox : Creates new axis aligned box between two corners
+func NewAxisAlignedBox(minCorner, maxCorner [3]float64) *AxisAlignedBox {
+	box := new(AxisAlignedBox)
+	for i := 0; i < 3; i++ {
+		box.Min[i] = math.Min(minCorner[i], maxCorner[i])
+		box.Max[i] = math.Max(minCorner[i], maxCorner[i])
+	}
+	return box
+}
+
+// NewOrientedBox : Creates new oriented box, the axes are normalized and
+// should be orthogonal
+func NewOrientedBox(center [3]float64, axes [3][3]float64, halfSizes [3]float64) *OrientedBox {
+	box := new(OrientedBox)
+	box.Center, box.HalfSizes = center, halfSizes
+	for i := 0; i < 3; i++ {
+		norm := math.Sqrt(axes[i][0]*axes[i][0] + axes[i][1]*axes[i][1] +
+			axes[i][2]*axes[i][2])
+		if norm == 0 {
+			panic("Oriented box axes shouldn't be zero")
+		}
+		for j := 0; j < 3; j++ {
+			box.Axes[i][j] = axes[i][j] / norm
+		}
+	}
+	return box
+}
+
+// NewConvexPolyhedron : Creates new convex polyhedron from its planes
+func NewConvexPolyhedron(planes [][4]float64) *ConvexPolyhedron {
+	polyhedron := new(ConvexPolyhedron)
+	polyhedron.Planes = append([][4]float64(nil), planes...)
+	return polyhedron
+}
+
+// Contains : Returns whether the point lies in the box
+func (box *AxisAlignedBox) Contains(point *mat.VecDense) bool {
+	w := point.AtVec(3)
+	for i := 0; i < 3; i++ {
+		val := point.AtVec(i) / w
+		if val < box.Min[i] || val > box.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains : Returns whether the point lies in the box
+func (box *OrientedBox) Contains(point *mat.VecDense) bool {
+	w := point.AtVec(3)
+	var diff [3]float64
+	for i := 0; i < 3; i++ {
+		diff[i] = point.AtVec(i)/w - box.Center[i]
+	}
+	for i := 0; i < 3; i++ {
+		proj := diff[0]*box.Axes[i][0] + diff[1]*box.Axes[i][1] + diff[2]*box.Axes[i][2]
+		if math.Abs(proj) > box.HalfSizes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains : Returns whether the point lies in the polyhedron
+func (polyhedron *ConvexPolyhedron) Contains(point *mat.VecDense) bool {
+	w := point.AtVec(3)
+	x, y, z := point.AtVec(0)/w, point.AtVec(1)/w, point.AtVec(2)/w
+	for _, plane := range polyhedron.Planes {
+		if plane[0]*x+plane[1]*y+plane[2]*z+plane[3] > 0 {
+			return false
+		}
+	}
+	return true
+}
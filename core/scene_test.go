@@ -0,0 +1,170 @@
+package core
+
+import (
+	"math"
+	"pmvs/image"
+)
+
+const (
+	sceneWidth  = 64
+	sceneHeight = 48
+	sceneFocal  = 50
+)
+
+// sceneCamera : A pinhole camera of the synthetic scenes, the rows of R are
+// the axes of the camera in world coordinates
+type sceneCamera struct {
+	R      [3][3]float64
+	center [3]float64
+}
+
+// lookAtOrigin : Returns the camera at center looking at the origin, with
+// the z axis pointing up in its image
+func lookAtOrigin(center [3]float64) sceneCamera {
+	z := normalize3([3]float64{-center[0], -center[1], -center[2]})
+	x := normalize3(cross3(z, [3]float64{0, 0, 1}))
+	y := cross3(z, x)
+	return sceneCamera{[3][3]float64{x, y, z}, center}
+}
+
+// projMat : Returns K [R | -R C]
+func (cam sceneCamera) projMat() []float64 {
+	K := [3][3]float64{
+		{sceneFocal, 0, sceneWidth / 2},
+		{0, sceneFocal, sceneHeight / 2},
+		{0, 0, 1},
+	}
+	projMat := make([]float64, 12)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				projMat[i*4+j] += K[i][k] * cam.R[k][j]
+			}
+		}
+		for k := 0; k < 3; k++ {
+			t := -(cam.R[k][0]*cam.center[0] + cam.R[k][1]*cam.center[1] +
+				cam.R[k][2]*cam.center[2])
+			projMat[i*4+3] += K[i][k] * t
+		}
+	}
+	return projMat
+}
+
+// ray : Returns the direction in world coordinates of the ray of pixel
+// (y, x)
+func (cam sceneCamera) ray(y, x float64) [3]float64 {
+	local := [3]float64{(x - sceneWidth/2) / sceneFocal, (y - sceneHeight/2) / sceneFocal, 1}
+	var ray [3]float64
+	for i := 0; i < 3; i++ {
+		ray[i] = cam.R[0][i]*local[0] + cam.R[1][i]*local[1] + cam.R[2][i]*local[2]
+	}
+	return ray
+}
+
+// hitSphere : Returns the first point where the ray from origin along
+// direction hits the sphere of given radius centered at the origin
+func hitSphere(origin, direction [3]float64, radius float64) (point [3]float64, ok bool) {
+	a := dot3(direction, direction)
+	b := 2 * dot3(direction, origin)
+	c := dot3(origin, origin) - radius*radius
+	disc := b*b - 4*a*c
+	if disc < 0 {
+		return point, false
+	}
+	t := (-b - math.Sqrt(disc)) / (2 * a)
+	for i := 0; i < 3; i++ {
+		point[i] = origin[i] + t*direction[i]
+	}
+	return point, t > 0
+}
+
+// hitPlane : Returns the point where the ray from origin along direction
+// hits the plane through the origin with the given normal
+func hitPlane(origin, direction, normal [3]float64) (point [3]float64, ok bool) {
+	denom := dot3(normal, direction)
+	if denom == 0 {
+		return point, false
+	}
+	t := -dot3(normal, origin) / denom
+	for i := 0; i < 3; i++ {
+		point[i] = origin[i] + t*direction[i]
+	}
+	return point, t > 0
+}
+
+// sceneTexture : Smooth color texture of the surfaces of the scenes
+func sceneTexture(p [3]float64) (r, g, b float32) {
+	v := math.Sin(7*p[0])*math.Cos(5*p[1]) + math.Sin(9*p[2]+3*p[0])
+	u := math.Cos(11*p[1]+2*p[2]) * math.Sin(6*p[0])
+	return float32(0.5 + 0.25*v), float32(0.5 + 0.4*u), float32(0.5 + 0.2*(v-u))
+}
+
+// sphereCameras : Returns cameras at distance from the origin, around the
+// equator and above and below it
+func sphereCameras(num int, distance float64) []sceneCamera {
+	cameras := make([]sceneCamera, num)
+	for i := range cameras {
+		azimuth := 2 * math.Pi * float64(i) / float64(num)
+		elevation := 0.5 * math.Sin(3*azimuth)
+		cameras[i] = lookAtOrigin([3]float64{
+			distance * math.Cos(elevation) * math.Cos(azimuth),
+			distance * math.Cos(elevation) * math.Sin(azimuth),
+			distance * math.Sin(elevation),
+		})
+	}
+	return cameras
+}
+
+// newSceneManager : Creates an images manager of photos taken by the
+// cameras of the textured surface whose first intersection with a ray is
+// given by hit, masked by the silhouette of the surface
+func newSceneManager(cameras []sceneCamera,
+	hit func(origin, direction [3]float64) ([3]float64, bool)) *ImagesManager {
+
+	imgs := make([]*image.CHWImage, len(cameras))
+	masks := make([]*image.CHWImage, len(cameras))
+	projMats := make([][]float64, len(cameras))
+	for i, cam := range cameras {
+		imgs[i] = image.NewImage(sceneHeight, sceneWidth, 3)
+		masks[i] = image.NewImage(sceneHeight, sceneWidth, 1)
+		for y := 0; y < sceneHeight; y++ {
+			for x := 0; x < sceneWidth; x++ {
+				point, ok := hit(cam.center, cam.ray(float64(y), float64(x)))
+				if !ok {
+					for c := 0; c < 3; c++ {
+						imgs[i].Set(y, x, c, 0.1)
+					}
+					continue
+				}
+				r, g, b := sceneTexture(point)
+				imgs[i].Set(y, x, 0, r)
+				imgs[i].Set(y, x, 1, g)
+				imgs[i].Set(y, x, 2, b)
+				masks[i].Set(y, x, 0, 1)
+			}
+		}
+		projMats[i] = cam.projMat()
+	}
+	return NewImagesManager(imgs, masks, projMats)
+}
+
+// newSphereManager : Creates an images manager of photos of the textured
+// unit sphere taken by the cameras
+func newSphereManager(cameras []sceneCamera) *ImagesManager {
+	return newSceneManager(cameras, func(origin, direction [3]float64) ([3]float64, bool) {
+		return hitSphere(origin, direction, 1)
+	})
+}
+
+func normalize3(a [3]float64) [3]float64 {
+	norm := math.Sqrt(dot3(a, a))
+	return [3]float64{a[0] / norm, a[1] / norm, a[2] / norm}
+}
+
+func cross3(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
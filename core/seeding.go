@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// SeedFromSparsePoints : Creates patches at the sparse points of the
+// dataset, as an alternative or a complement to the initial matching.
+// The reference photo of a point is the first photo that sees it and has a
+// free cell there, and the other photos seeing it are the candidates.
+// If ctx is cancelled the seeding stops, keeping the patches registered so
+// far, and returns the error of ctx
+func SeedFromSparsePoints(ctx context.Context) error {
+	const phase = "seeding"
+	numPoints := len(imgsManager.SparsePoints)
+	observer.PhaseStarted(phase, numPoints)
+	start := time.Now()
+
+	num := 0
+	for i, point := range imgsManager.SparsePoints {
+		if err := ctx.Err(); err != nil {
+			observer.PhaseEnded(phase, num, time.Since(start))
+			return err
+		}
+		observer.Progress(phase, i+1, numPoints)
+		if len(point.Photos) < 3 {
+			continue
+		}
+		for _, photoID := range point.Photos {
+			cell := imgsManager.Photos[photoID].projectToCell(point.Position)
+			if cell == nil || len(cell.Patches) != 0 {
+				continue
+			}
+
+			searchIDs := make([]int, 0, len(point.Photos)-1)
+			for _, id := range point.Photos {
+				if id != photoID {
+					searchIDs = append(searchIDs, id)
+				}
+			}
+			patch := new(Patch)
+			patch.Center = mat.VecDenseCopyOf(point.Position)
+			patch.Normal = mat.NewVecDense(4, nil)
+			patch.RefPhoto = photoID
+			if registered, _ := refinePatch(ctx, patch, searchIDs); registered {
+				num++
+			}
+			break
+		}
+	}
+	observer.PhaseEnded(phase, num, time.Since(start))
+	return nil
+}
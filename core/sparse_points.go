@@ -0,0 +1,33 @@
+package core
+
+import "gonum.org/v1/gonum/mat"
+
+// SparsePoint : A point of a sparse reconstruction and the photos seeing it
+type SparsePoint struct {
+	Position *mat.VecDense
+	Photos   []int
+}
+
+// SetSparsePoints : Sets the sparse points of the dataset, positions[i] holds
+// the x, y, z coordinates of point i and visibility[i] the ids of the photos
+// in which it was observed
+func (imgsManager *ImagesManager) SetSparsePoints(positions [][]float64, visibility [][]int) {
+	if len(positions) != len(visibility) {
+		panic("Number of sparse points and visibility lists aren't equal")
+	}
+	points := make([]*SparsePoint, 0, len(positions))
+	for i, pos := range positions {
+		if len(pos) != 3 {
+			panic("Sparse point positions should be of size 3")
+		}
+		point := new(SparsePoint)
+		point.Position = mat.NewVecDense(4, []float64{pos[0], pos[1], pos[2], 1})
+		point.Photos = append([]int(nil), visibility[i]...)
+		points = append(points, point)
+	}
+	imgsManager.SparsePoints = points
+	// neighbours depend on the sparse points
+	for _, photo := range imgsManager.Photos {
+		photo.neighbours = nil
+	}
+}
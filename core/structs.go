@@ -3,8 +3,10 @@ package core
 import (
 	"pmvs/featdetect"
 	"pmvs/image"
+	"sync"
 
 	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
 )
 
 var (
@@ -15,14 +17,32 @@ var (
 	})
 )
 
-// ImagesManager : Container of input and output data
+// ImagesManager : Container of input and output data. Removed patches
+// leave nil entries in Patches until CompactPatches is called. Patches are
+// registered and removed under a mutex, so that several goroutines can do
+// it, but the cells shouldn't be read meanwhile
 type ImagesManager struct {
-	Photos   []*Photo
-	FundMats [][]*mat.Dense
-	Patches  []*Patch
+	Photos        []*Photo
+	FundMats      map[[2]int]*mat.Dense
+	Patches       []*Patch
+	PatchIndex    *PatchIndex
+	Hull          *VisualHull
+	SparsePoints  []*SparsePoint
+	RejectedByROI int
+	Phase         Phase
+	Iteration     int
+	Report        *MatchingReport
+
+	// clusters are reconstructed by managers of their own
+	isCluster bool
+
+	patchesMutex   sync.Mutex
+	nextPatchID    int
+	removedPatches int
 }
 
-// Photo : An image with its camera
+// Photo : An image with its camera. Images derived from Img are computed
+// on first use, resetDerived should be called whenever Img changes
 type Photo struct {
 	Img   *image.CHWImage
 	Mask  *image.CHWImage
@@ -30,6 +50,9 @@ type Photo struct {
 	Cells [][]*Cell
 	Feats [][]*featdetect.Feature
 	ID    int
+
+	neighbours []int
+	derived    *derivedImages
 }
 
 // Cell : Photos are divided into cells that contain patches
@@ -37,20 +60,45 @@ type Cell struct {
 	Patches []*Patch
 }
 
-// Camera : Relevant camera information
+// Camera : Relevant camera information, ProjMat = s * K [R | T] for some
+// non zero scale s, which is negative when the matrix was given up to a
+// negative factor. scaleSign records the sign of s
 type Camera struct {
 	ProjMat       *mat.Dense
 	OpticalAxis   *mat.VecDense
 	OpticalCenter *mat.VecDense
 	Pinv          *mat.Dense
+	K             *mat.Dense
+	R             *mat.Dense
+	T             *mat.VecDense
+
+	// sign of s
+	scaleSign float64
 }
 
-// Patch : A rectangle in 3D
+// Patch : A rectangle in 3D. VPhotos are the photos the patch is visible
+// in and TPhotos those of them that agree with the reference photo, the
+// reference photo itself is in neither. ID is given at registration and
+// never changes, Score is the PhotoMeasure between the reference photo and
+// each of TPhotos combined by the Aggregation of the options, Size the side
+// of the patch in world units as sampled in the reference photo, Scale the
+// distance in pixels between the samples of its grid in the reference photo
+// and Status how its optimization ended
 type Patch struct {
+	ID       int
 	Normal   *mat.VecDense
 	Center   *mat.VecDense
 	RefPhoto int
+	VPhotos  []int
 	TPhotos  []int
+	Score    float64
+	Size     float64
+	Scale    float64
+	Status   optimize.Status
+
+	// position in the list of patches and cells holding the patch
+	index int
+	cells []*Cell
 }
 
 // NewImagesManager : Creates new ImagesManager
@@ -61,13 +109,14 @@ func NewImagesManager(imgs, masks []*image.CHWImage, projMats [][]float64) *Imag
 		panic("Number of images and projection matrices aren't equal")
 	}
 	photos := make([]*Photo, length, length)
-	fundMats := make([][]*mat.Dense, length, length)
 	for i := 0; i < length; i++ {
-		fundMats[i] = make([]*mat.Dense, length, length)
 		photos[i] = newPhoto(imgs[i], masks[i], projMats[i], i)
 	}
 	imgsManager = new(ImagesManager)
-	imgsManager.Photos, imgsManager.FundMats = photos, fundMats
+	imgsManager.Photos = photos
+	imgsManager.PatchIndex = NewPatchIndex()
+	// fundamental matrices are only computed for the pairs that are used
+	imgsManager.FundMats = make(map[[2]int]*mat.Dense)
 	return imgsManager
 }
 
@@ -75,22 +124,29 @@ func newPhoto(img, mask *image.CHWImage, projMat []float64, id int) *Photo {
 	photo := new(Photo)
 	photo.Img, photo.Mask = img, mask
 	photo.ID = id
+	photo.derived = new(derivedImages)
 	photo.Cam = newCamera(projMat)
-	cellsWidth := (photo.Img.Width + cellSize - 1) / cellSize
-	cellsHeight := (photo.Img.Height + cellSize - 1) / cellSize
-	photo.Cells = make([][]*Cell, cellsHeight, cellsHeight)
-	for i := 0; i < cellsHeight; i++ {
-		photo.Cells[i] = make([]*Cell, cellsWidth, cellsWidth)
-		for j := 0; j < cellsWidth; j++ {
-			photo.Cells[i][j] = new(Cell)
-		}
-	}
+	photo.Cells = newCells(photo.Img.Width, photo.Img.Height)
 
 	photo.Cam.Pinv = mat.NewDense(4, 3, nil)
 	photo.Cam.Pinv.Solve(photo.Cam.ProjMat, eye3)
 	return photo
 }
 
+// newCells : Creates the empty cells covering an image of the given size
+func newCells(width, height int) [][]*Cell {
+	cellsWidth := (width + cellSize - 1) / cellSize
+	cellsHeight := (height + cellSize - 1) / cellSize
+	cells := make([][]*Cell, cellsHeight, cellsHeight)
+	for i := 0; i < cellsHeight; i++ {
+		cells[i] = make([]*Cell, cellsWidth, cellsWidth)
+		for j := 0; j < cellsWidth; j++ {
+			cells[i][j] = new(Cell)
+		}
+	}
+	return cells
+}
+
 func newCamera(projMatData []float64) *Camera {
 	if len(projMatData) != 3*4 {
 		panic("Projection matrix should be of size 3x4")
@@ -106,21 +162,18 @@ func newCamera(projMatData []float64) *Camera {
 		-opticalCenter.AtVec(2), 1,
 	})
 
-	opticalAxis := mat.NewVecDense(4, []float64{
-		projMat.At(2, 0), projMat.At(2, 1), projMat.At(2, 2), 0,
-	})
-
 	camera := new(Camera)
 	camera.ProjMat = projMat
-	camera.OpticalAxis = opticalAxis
 	camera.OpticalCenter = opticalCenter
+	camera.decompose()
+	camera.OpticalAxis = camera.ViewDirection()
 	return camera
 }
 
 // FundamentalMatrix : Return the fundamental matrix relating two images
 func (imgsManager *ImagesManager) FundamentalMatrix(id1, id2 int) *mat.Dense {
-	if imgsManager.FundMats[id1][id2] != nil {
-		return imgsManager.FundMats[id1][id2]
+	if funMat, ok := imgsManager.FundMats[[2]int{id1, id2}]; ok {
+		return funMat
 	}
 	photo1 := imgsManager.Photos[id1]
 	photo2 := imgsManager.Photos[id2]
@@ -135,8 +188,8 @@ func (imgsManager *ImagesManager) FundamentalMatrix(id1, id2 int) *mat.Dense {
 
 	funMat := mat.NewDense(3, 3, nil)
 	funMat.Mul(skewForm(epipole), ppinv)
-	imgsManager.FundMats[id1][id2] = funMat
-	imgsManager.FundMats[id2][id1] = mat.DenseCopyOf(funMat.T())
+	imgsManager.FundMats[[2]int{id1, id2}] = funMat
+	imgsManager.FundMats[[2]int{id2, id1}] = mat.DenseCopyOf(funMat.T())
 	return funMat
 }
 
@@ -150,7 +203,7 @@ func (photo *Photo) OpticalCenter() *mat.VecDense {
 	return photo.Cam.OpticalCenter
 }
 
-// OpticalAxis : Return the optical axis
+// OpticalAxis : Return the unit vector along which the camera looks
 func (photo *Photo) OpticalAxis() *mat.VecDense {
 	return photo.Cam.OpticalAxis
 }
@@ -167,6 +220,14 @@ func (photo *Photo) At(y, x float64) (r, g, b float32) {
 		photo.Img.At(yint, xint, 2)
 }
 
+// Contains : Return whether (y, x) lies inside the image
+func (photo *Photo) Contains(y, x float64) bool {
+	xint := int(x + 0.5)
+	yint := int(y + 0.5)
+	return x+0.5 >= 0 && y+0.5 >= 0 && xint < photo.Img.Width &&
+		yint < photo.Img.Height
+}
+
 // IsMasked : Return whether (y, x) is masked or not
 func (photo *Photo) IsMasked(y, x float64) bool {
 	xint := int(x + 0.5)
@@ -180,6 +241,31 @@ func (photo *Photo) IsMasked(y, x float64) bool {
 	return photo.Mask.At(yint, xint, 0) == 0
 }
 
+// cellAt : Returns the cell holding pixel (y, x), or nil if the pixel is
+// outside the image
+func (photo *Photo) cellAt(y, x int) *Cell {
+	if x < 0 || y < 0 || x >= photo.Img.Width || y >= photo.Img.Height {
+		return nil
+	}
+	return photo.Cells[y/cellSize][x/cellSize]
+}
+
+// projectToCell : Returns the cell the homogeneous point projects to, or
+// nil if the point is behind the camera or projects outside the image
+func (photo *Photo) projectToCell(point *mat.VecDense) *Cell {
+	if !photo.Cam.InFront(point) {
+		return nil
+	}
+	projected := mat.NewVecDense(3, nil)
+	projected.MulVec(photo.CameraMatrix(), point)
+	x := projected.AtVec(0) / projected.AtVec(2)
+	y := projected.AtVec(1) / projected.AtVec(2)
+	if !photo.Contains(y, x) {
+		return nil
+	}
+	return photo.cellAt(int(y), int(x))
+}
+
 // skewForm : skewForm(v).dot(u) = v cross u
 func skewForm(vec *mat.VecDense) *mat.Dense {
 	return mat.NewDense(3, 3, []float64{
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestProjectToCell(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	// the second camera is the first one up to a negative scale
+	manager := newTestManager(testProjMat(1, 0), testProjMat(-1, 0))
+	if sign := manager.Photos[1].Cam.scaleSign; sign != -1 {
+		t.Fatalf("scale sign is %v, want -1", sign)
+	}
+
+	tests := []struct {
+		name  string
+		point []float64
+		// pixel the point projects to, ignored if it has no cell
+		y, x   int
+		inCell bool
+	}{
+		{"valid", []float64{0.5, -0.25, 5, 1}, 14, 22, true},
+		{"outside the image", []float64{10, 0, 5, 1}, 0, 60, false},
+		{"behind the camera", []float64{0.5, -0.25, -5, 1}, 0, 0, false},
+		{"scaled homogeneous", []float64{-1, 0.5, 10, 2}, 16, 18, true},
+	}
+	for _, photo := range manager.Photos {
+		for _, test := range tests {
+			cell := photo.projectToCell(mat.NewVecDense(4, test.point))
+			if !test.inCell {
+				if cell != nil {
+					t.Errorf("photo %v, %v: got a cell, want nil", photo.ID, test.name)
+				}
+				continue
+			}
+			want := photo.Cells[test.y/cellSize][test.x/cellSize]
+			if cell != want {
+				t.Errorf("photo %v, %v: got another cell than the one of (%v, %v)",
+					photo.ID, test.name, test.y, test.x)
+			}
+			if photo.cellAt(test.y, test.x) != want {
+				t.Errorf("photo %v, %v: cellAt(%v, %v) isn't the projected cell",
+					photo.ID, test.name, test.y, test.x)
+			}
+			if getCell(photo.ID, test.y, test.x) != want {
+				t.Errorf("photo %v, %v: getCell(%v, %v) isn't the projected cell",
+					photo.ID, test.name, test.y, test.x)
+			}
+		}
+	}
+}
+
+func TestCellAtOutside(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	newTestManager(testProjMat(1, 0))
+	pixels := [][2]int{
+		{-1, 0}, {0, -1}, {testHeight, 0}, {0, testWidth},
+		{testHeight, testWidth},
+	}
+	for _, pixel := range pixels {
+		if imgsManager.Photos[0].cellAt(pixel[0], pixel[1]) != nil {
+			t.Errorf("cellAt%v: got a cell, want nil", pixel)
+		}
+		if getCell(0, pixel[0], pixel[1]) != nil {
+			t.Errorf("getCell%v: got a cell, want nil", pixel)
+		}
+	}
+	if imgsManager.Photos[0].cellAt(testHeight-1, testWidth-1) == nil {
+		t.Errorf("cellAt(%v, %v): got nil, want the last cell",
+			testHeight-1, testWidth-1)
+	}
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// number of samples per side of the grid used to measure frustum overlap
+	overlapGridSize = 5
+)
+
+// viewScore : A candidate neighbour and how suitable it is
+type viewScore struct {
+	photoID int
+	score   float64
+}
+
+// selectViews : Scores every other photo as a neighbour of photo id and
+// returns the ids of the best options.NumNeighbours of them, best first.
+// The score is the product of terms for the triangulation angle, the
+// baseline to depth ratio, the overlap of the frusta, the similarity of
+// image scales and the number of shared sparse points. Photos sharing no
+// sparse points keep the other terms, scaled down to rank below the photos
+// sharing some
+func selectViews(id int) []int {
+	photo := imgsManager.Photos[id]
+	sharedPoints, numPoints := sharedSparsePoints(id)
+	center := photoSceneCenter(photo)
+
+	scores := make([]viewScore, 0, len(imgsManager.Photos))
+	for i, photo2 := range imgsManager.Photos {
+		if i == id {
+			continue
+		}
+		if mat.Dot(photo.OpticalAxis(), photo2.OpticalAxis()) < cosMaxAngle {
+			continue
+		}
+		scenePoint := center
+		if scenePoint == nil {
+			scenePoint = axesCenter(photo, photo2)
+		}
+		if scenePoint == nil {
+			continue
+		}
+		score := angleScore(photo, photo2, scenePoint) *
+			baselineScore(photo, photo2, scenePoint) *
+			overlapScore(photo, photo2, scenePoint) *
+			scaleScore(photo, photo2, scenePoint)
+		if numPoints > 0 {
+			score *= float64(sharedPoints[i]+1) / float64(numPoints+1)
+		}
+		if score > 0 {
+			scores = append(scores, viewScore{i, score})
+		}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
+	if len(scores) > options.NumNeighbours {
+		scores = scores[:options.NumNeighbours]
+	}
+
+	result := make([]int, len(scores))
+	for i, s := range scores {
+		result[i] = s.photoID
+	}
+	return result
+}
+
+// sharedSparsePoints : Counts for every photo the sparse points it shares
+// with photo id, along with the number of sparse points seen by photo id
+func sharedSparsePoints(id int) (shared []int, numPoints int) {
+	shared = make([]int, len(imgsManager.Photos))
+	for _, point := range imgsManager.SparsePoints {
+		if !containsInt(point.Photos, id) {
+			continue
+		}
+		numPoints++
+		for _, photoID := range point.Photos {
+			shared[photoID]++
+		}
+	}
+	return
+}
+
+// photoSceneCenter : Estimates the part of the scene photo looks at, the
+// centroid of the sparse points, or the registered patches, seen by photo.
+// Returns nil if there are none
+func photoSceneCenter(photo *Photo) *mat.VecDense {
+	center := mat.NewVecDense(4, nil)
+	num := 0
+	for _, point := range imgsManager.SparsePoints {
+		if containsInt(point.Photos, photo.ID) {
+			center.AddVec(center, point.Position)
+			num++
+		}
+	}
+	if num == 0 {
+		for _, patch := range imgsManager.Patches {
+			if patch != nil && patch.RefPhoto == photo.ID {
+				center.AddVec(center, patch.Center)
+				num++
+			}
+		}
+	}
+	if num == 0 {
+		return nil
+	}
+	center.ScaleVec(1/float64(num), center)
+	return center
+}
+
+// axesCenter : Returns the point nearest to the optical axes of both photos,
+// nil if they are parallel or meet behind photo
+func axesCenter(photo, photo2 *Photo) *mat.VecDense {
+	c1, c2 := photo.OpticalCenter(), photo2.OpticalCenter()
+	d1, d2 := photo.OpticalAxis(), photo2.OpticalAxis()
+	w := mat.NewVecDense(4, nil)
+	w.SubVec(c1, c2)
+	b := mat.Dot(d1, d2)
+	denom := 1 - b*b
+	if denom < 1e-9 {
+		return nil
+	}
+	t1 := (b*mat.Dot(d2, w) - mat.Dot(d1, w)) / denom
+	if t1 <= 0 {
+		return nil
+	}
+	center := mat.NewVecDense(4, nil)
+	center.AddScaledVec(c1, t1, d1)
+	return center
+}
+
+// angleScore : Prefers triangulation angles above the minimum angle and
+// rejects angles above the maximum angle
+func angleScore(photo, photo2 *Photo, point *mat.VecDense) float64 {
+	ray1, ray2 := mat.NewVecDense(4, nil), mat.NewVecDense(4, nil)
+	ray1.SubVec(photo.OpticalCenter(), point)
+	ray2.SubVec(photo2.OpticalCenter(), point)
+	cosAngle := mat.Dot(ray1, ray2) / (mat.Norm(ray1, 2) * mat.Norm(ray2, 2))
+	angle := math.Acos(math.Max(-1, math.Min(1, cosAngle))) * 180 / math.Pi
+	if angle > options.MaxTriangulationAngle {
+		return 0
+	}
+	ratio := angle / options.MinTriangulationAngle
+	return math.Min(ratio*ratio, 1)
+}
+
+// baselineScore : Penalizes baselines that are short relative to the depth
+func baselineScore(photo, photo2 *Photo, point *mat.VecDense) float64 {
+	baseline, depthVector := mat.NewVecDense(4, nil), mat.NewVecDense(4, nil)
+	baseline.SubVec(photo.OpticalCenter(), photo2.OpticalCenter())
+	depthVector.SubVec(photo.OpticalCenter(), point)
+	ratio := mat.Norm(baseline, 2) / mat.Norm(depthVector, 2)
+	return math.Min(ratio/options.MinBaselineRatio, 1)
+}
+
+// overlapScore : Fraction of a grid of pixels of photo, back-projected to the
+// depth of point, that is seen by photo2
+func overlapScore(photo, photo2 *Photo, point *mat.VecDense) float64 {
+	depth := pointDepth(photo, point)
+	if depth <= 0 {
+		return 0
+	}
+	width, height := float64(photo.Img.Width), float64(photo.Img.Height)
+	projected := mat.NewVecDense(3, nil)
+	seen := 0
+	for i := 0; i < overlapGridSize; i++ {
+		for j := 0; j < overlapGridSize; j++ {
+			x := (float64(j) + 0.5) * width / overlapGridSize
+			y := (float64(i) + 0.5) * height / overlapGridSize
+			sample := backProject(photo, x, y, depth)
+			if pointDepth(photo2, sample) <= 0 {
+				continue
+			}
+			projected.MulVec(photo2.CameraMatrix(), sample)
+			if photo2.Contains(projected.AtVec(1)/projected.AtVec(2),
+				projected.AtVec(0)/projected.AtVec(2)) {
+				seen++
+			}
+		}
+	}
+	return float64(seen) / (overlapGridSize * overlapGridSize)
+}
+
+// scaleScore : Compares the size of a pixel at point in both photos, views
+// that see the point at a very different resolution are penalized
+func scaleScore(photo, photo2 *Photo, point *mat.VecDense) float64 {
+	scale1, scale2 := pixelSize(photo, point), pixelSize(photo2, point)
+	if scale1 <= 0 || scale2 <= 0 {
+		return 0
+	}
+	return math.Min(scale1, scale2) / math.Max(scale1, scale2)
+}
+
+// pixelSize : Returns the size in world units of a pixel of photo at point
+func pixelSize(photo *Photo, point *mat.VecDense) float64 {
+	depth := pointDepth(photo, point)
+	if depth <= 0 {
+		return 0
+	}
+	fx, fy := photo.Cam.FocalLength()
+	return depth / math.Sqrt(fx*fy)
+}
+
+// pointDepth : Returns the depth of point along the forward axis of photo,
+// negative for points behind the camera
+func pointDepth(photo *Photo, point *mat.VecDense) float64 {
+	diff := mat.NewVecDense(4, nil)
+	diff.SubVec(point, photo.OpticalCenter())
+	return mat.Dot(diff, photo.OpticalAxis())
+}
+
+// backProject : Returns the point that projects to (x, y) in photo and lies
+// at the given depth, that is C + depth * R^T K^-1 (x, y, 1)
+func backProject(photo *Photo, x, y, depth float64) *mat.VecDense {
+	cam := photo.Cam
+	rayCam, ray := mat.NewVecDense(3, nil), mat.NewVecDense(3, nil)
+	rayCam.SolveVec(cam.K, mat.NewVecDense(3, []float64{x, y, 1}))
+	ray.MulVec(cam.R.T(), rayCam)
+	point := mat.NewVecDense(4, []float64{
+		ray.AtVec(0), ray.AtVec(1), ray.AtVec(2), 0,
+	})
+	point.ScaleVec(depth/rayCam.AtVec(2), point)
+	point.AddVec(photo.OpticalCenter(), point)
+	return point
+}
+
+func containsInt(slice []int, val int) bool {
+	for _, elem := range slice {
+		if elem == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,357 @@
+package core
+
+import (
+	"math"
+	"pmvs/mesh"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type hullState int
+
+const (
+	hullInside hullState = iota
+	hullOutside
+	hullMixed
+)
+
+// hullNode : A cube of the carved volume, mixed cubes are refined into eight
+// children until the maximum depth is reached
+type hullNode struct {
+	children []*hullNode
+	state    hullState
+}
+
+// VisualHull : The intersection of the cones back-projected from the
+// silhouettes of all photos, stored as a grid of octrees
+type VisualHull struct {
+	Min       [3]float64
+	Dims      [3]int
+	CellSide  float64
+	Depth     int
+	cells     []*hullNode
+	integrals [][]float64
+}
+
+// BuildVisualHull : Carves the box between minCorner and maxCorner using the
+// masks of all photos. The box is divided into cubic cells, resolution
+// along its longest side, and cells on the boundary of the hull are
+// refined depth more times. The hull is kept by the manager and used by
+// the visual hull checks from then on
+func (imgsManager *ImagesManager) BuildVisualHull(minCorner, maxCorner [3]float64,
+	resolution, depth int) *VisualHull {
+
+	if resolution <= 0 || depth < 0 {
+		panic("Visual hull resolution should be positive and depth non-negative")
+	}
+	hull := new(VisualHull)
+	hull.Min, hull.Depth = minCorner, depth
+	var extent float64
+	for i := 0; i < 3; i++ {
+		extent = math.Max(extent, maxCorner[i]-minCorner[i])
+	}
+	hull.CellSide = extent / float64(resolution)
+	for i := 0; i < 3; i++ {
+		hull.Dims[i] = int(math.Ceil((maxCorner[i] - minCorner[i]) / hull.CellSide))
+		if hull.Dims[i] == 0 {
+			hull.Dims[i] = 1
+		}
+	}
+
+	hull.integrals = make([][]float64, len(imgsManager.Photos))
+	for i, photo := range imgsManager.Photos {
+		if photo.Mask != nil {
+			hull.integrals[i] = maskIntegral(photo)
+		}
+	}
+
+	hull.cells = make([]*hullNode, hull.Dims[0]*hull.Dims[1]*hull.Dims[2])
+	for z := 0; z < hull.Dims[2]; z++ {
+		for y := 0; y < hull.Dims[1]; y++ {
+			for x := 0; x < hull.Dims[0]; x++ {
+				corner := [3]float64{
+					hull.Min[0] + float64(x)*hull.CellSide,
+					hull.Min[1] + float64(y)*hull.CellSide,
+					hull.Min[2] + float64(z)*hull.CellSide,
+				}
+				hull.cells[hull.cellIndex(x, y, z)] =
+					hull.carve(corner, hull.CellSide, depth)
+			}
+		}
+	}
+	// the integral images are only needed while carving
+	hull.integrals = nil
+	imgsManager.Hull = hull
+	return hull
+}
+
+func (hull *VisualHull) cellIndex(x, y, z int) int {
+	return (z*hull.Dims[1]+y)*hull.Dims[0] + x
+}
+
+// carve : Classifies the cube at corner with the given side refining it if
+// it is mixed and depth allows
+func (hull *VisualHull) carve(corner [3]float64, side float64, depth int) *hullNode {
+	node := new(hullNode)
+	node.state = hull.classify(corner, side)
+	if node.state != hullMixed || depth == 0 {
+		return node
+	}
+	node.children = make([]*hullNode, 8)
+	half := side / 2
+	for c := 0; c < 8; c++ {
+		childCorner := [3]float64{
+			corner[0] + float64(c&1)*half,
+			corner[1] + float64((c>>1)&1)*half,
+			corner[2] + float64((c>>2)&1)*half,
+		}
+		node.children[c] = hull.carve(childCorner, half, depth-1)
+	}
+	return node
+}
+
+// classify : Projects the cube into every photo and compares the bounding
+// rectangle of its projection with the mask. The cube is outside if the
+// rectangle is fully masked in any photo, and inside if it is fully
+// unmasked in every photo. Parts of the projection that fall outside an
+// image say nothing about the cube, as in the per point check, and neither
+// do cubes that aren't entirely in front of the camera
+func (hull *VisualHull) classify(corner [3]float64, side float64) hullState {
+	state := hullInside
+	point := mat.NewVecDense(4, nil)
+	projected := mat.NewVecDense(3, nil)
+	for photoID, photo := range imgsManager.Photos {
+		integral := hull.integrals[photoID]
+		if integral == nil {
+			continue
+		}
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		valid := true
+		for c := 0; c < 8; c++ {
+			point.SetVec(0, corner[0]+float64(c&1)*side)
+			point.SetVec(1, corner[1]+float64((c>>1)&1)*side)
+			point.SetVec(2, corner[2]+float64((c>>2)&1)*side)
+			point.SetVec(3, 1)
+			// corners behind the camera project with flipped signs, the
+			// rectangle of the projection says nothing then
+			if !photo.Cam.InFront(point) {
+				valid = false
+				break
+			}
+			projected.MulVec(photo.CameraMatrix(), point)
+			scale := projected.AtVec(2)
+			x, y := projected.AtVec(0)/scale, projected.AtVec(1)/scale
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		if !valid {
+			state = hullMixed
+			continue
+		}
+
+		width, height := photo.Img.Width, photo.Img.Height
+		x0, y0 := int(math.Floor(minX+0.5)), int(math.Floor(minY+0.5))
+		x1, y1 := int(math.Floor(maxX+0.5)), int(math.Floor(maxY+0.5))
+		if x1 < 0 || y1 < 0 || x0 >= width || y0 >= height {
+			// the cube isn't seen by this photo
+			continue
+		}
+		clipped := x0 < 0 || y0 < 0 || x1 >= width || y1 >= height
+		x0, y0 = maxInt(x0, 0), maxInt(y0, 0)
+		x1, y1 = minInt(x1, width-1), minInt(y1, height-1)
+
+		area := float64((x1 - x0 + 1) * (y1 - y0 + 1))
+		unmasked := rectSum(integral, width, x0, y0, x1, y1)
+		if unmasked == 0 && !clipped {
+			return hullOutside
+		}
+		if unmasked != area || clipped {
+			state = hullMixed
+		}
+	}
+	return state
+}
+
+// Contains : Returns whether the point lies inside the visual hull. Points
+// outside the carved box and points in mixed cubes at the maximum depth are
+// checked against the masks directly, points that aren't finite, such as
+// points at infinity, are outside
+func (hull *VisualHull) Contains(point *mat.VecDense) bool {
+	position := dehomogenize(point)
+	if !isFinite3(position) {
+		return false
+	}
+	var local [3]float64
+	var cell [3]int
+	for i := 0; i < 3; i++ {
+		local[i] = (position[i] - hull.Min[i]) / hull.CellSide
+		if local[i] < 0 || local[i] >= float64(hull.Dims[i]) {
+			return masksCheck(point)
+		}
+		cell[i] = int(local[i])
+		local[i] -= float64(cell[i])
+	}
+
+	node := hull.cells[hull.cellIndex(cell[0], cell[1], cell[2])]
+	for node.children != nil {
+		child := 0
+		for i := 0; i < 3; i++ {
+			local[i] *= 2
+			if local[i] >= 1 {
+				child |= 1 << uint(i)
+				local[i]--
+			}
+		}
+		node = node.children[child]
+	}
+	switch node.state {
+	case hullInside:
+		return true
+	case hullOutside:
+		return false
+	}
+	return masksCheck(point)
+}
+
+// Mesh : Extracts the surface of the visual hull at the finest resolution.
+// The leaves of the octrees are uniform inside, so only the cubes of the
+// finest grid along their faces are visited and the work is proportional to
+// the surface of the leaves rather than to the volume of the box
+func (hull *VisualHull) Mesh() *mesh.Mesh {
+	cellsPerSide := 1 << uint(hull.Depth)
+	// one layer of empty nodes around the box closes the surface
+	grid := new(mesh.Grid)
+	grid.Step = hull.CellSide / float64(cellsPerSide)
+	for i := 0; i < 3; i++ {
+		grid.Size[i] = hull.Dims[i]*cellsPerSide + 3
+		grid.Origin[i] = hull.Min[i] - grid.Step
+	}
+
+	cubes := make(map[[3]int]bool)
+	for z := 0; z < hull.Dims[2]; z++ {
+		for y := 0; y < hull.Dims[1]; y++ {
+			for x := 0; x < hull.Dims[0]; x++ {
+				// nodes of the grid are shifted by the empty layer
+				first := [3]int{x*cellsPerSide + 1, y*cellsPerSide + 1, z*cellsPerSide + 1}
+				hull.leafCubes(hull.cells[hull.cellIndex(x, y, z)], first,
+					cellsPerSide, cubes)
+			}
+		}
+	}
+	sorted := make([][3]int, 0, len(cubes))
+	for cube := range cubes {
+		sorted = append(sorted, cube)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a[2] != b[2] {
+			return a[2] < b[2]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[0] < b[0]
+	})
+
+	point := mat.NewVecDense(4, nil)
+	point.SetVec(3, 1)
+	return mesh.ExtractIsoSurfaceCubes(grid, sorted, 0.5, func(x, y, z int) float64 {
+		node := [3]int{x, y, z}
+		pos := grid.Position(x, y, z)
+		for i, n := range node {
+			if n == 0 || n == grid.Size[i]-1 {
+				return 0
+			}
+			// nodes on the far faces of the box are nudged inside it
+			if n == grid.Size[i]-2 {
+				pos[i] -= grid.Step * 1e-6
+			}
+		}
+		point.SetVec(0, pos[0])
+		point.SetVec(1, pos[1])
+		point.SetVec(2, pos[2])
+		if hull.Contains(point) {
+			return 1
+		}
+		return 0
+	})
+}
+
+// leafCubes : Adds to cubes the cubes of the finest grid the surface may
+// cross around the leaves of the node whose first grid node is first and
+// whose side is size cubes. The grid nodes of a leaf, those from its first
+// node up to but excluding its far faces, all have the state of the leaf,
+// so only the cubes reaching past the leaf can have nodes of different
+// states. Those are the last layer of cubes of the leaf, and the layers
+// just outside it for the cubes next to the empty layer around the box
+func (hull *VisualHull) leafCubes(node *hullNode, first [3]int, size int,
+	cubes map[[3]int]bool) {
+
+	if node.children != nil {
+		half := size / 2
+		for c := 0; c < 8; c++ {
+			childFirst := [3]int{
+				first[0] + (c&1)*half,
+				first[1] + ((c>>1)&1)*half,
+				first[2] + ((c>>2)&1)*half,
+			}
+			hull.leafCubes(node.children[c], childFirst, half, cubes)
+		}
+		return
+	}
+	onBoundary := func(d int) bool {
+		return d == -1 || d == size-1 || d == size
+	}
+	for dz := -1; dz <= size; dz++ {
+		for dy := -1; dy <= size; dy++ {
+			for dx := -1; dx <= size; dx++ {
+				if !onBoundary(dz) && !onBoundary(dy) && !onBoundary(dx) {
+					// skip to the last layer
+					dx = size - 2
+					continue
+				}
+				cubes[[3]int{first[0] + dx, first[1] + dy, first[2] + dz}] = true
+			}
+		}
+	}
+}
+
+// maskIntegral : Returns the summed area table of the binarized mask of a
+// photo, with an extra leading row and column of zeros
+func maskIntegral(photo *Photo) []float64 {
+	width, height := photo.Img.Width, photo.Img.Height
+	integral := make([]float64, (width+1)*(height+1))
+	for y := 0; y < height; y++ {
+		var rowSum float64
+		for x := 0; x < width; x++ {
+			if photo.Mask.At(y, x, 0) != 0 {
+				rowSum++
+			}
+			integral[(y+1)*(width+1)+x+1] = integral[y*(width+1)+x+1] + rowSum
+		}
+	}
+	return integral
+}
+
+// rectSum : Sums the table over the inclusive rectangle (x0, y0) - (x1, y1)
+func rectSum(integral []float64, width, x0, y0, x1, y1 int) float64 {
+	stride := width + 1
+	return integral[(y1+1)*stride+x1+1] - integral[y0*stride+x1+1] -
+		integral[(y1+1)*stride+x0] + integral[y0*stride+x0]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"math"
+	"pmvs/mesh"
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// newSphereHull : Carves the visual hull of the unit sphere seen by ten
+// photos, in a box of side 3 split into 6 cells refined twice
+func newSphereHull() *VisualHull {
+	manager := newSphereManager(sphereCameras(10, 4))
+	return manager.BuildVisualHull([3]float64{-1.5, -1.5, -1.5},
+		[3]float64{1.5, 1.5, 1.5}, 6, 2)
+}
+
+func TestVisualHullClassify(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	hull := newSphereHull()
+	hull.integrals = make([][]float64, len(imgsManager.Photos))
+	for i, photo := range imgsManager.Photos {
+		hull.integrals[i] = maskIntegral(photo)
+	}
+
+	tests := []struct {
+		name   string
+		corner [3]float64
+		side   float64
+		want   hullState
+	}{
+		{"center", [3]float64{-0.2, -0.2, -0.2}, 0.4, hullInside},
+		{"corner of the box", [3]float64{1.1, 1.1, 1.1}, 0.3, hullOutside},
+		{"across the surface", [3]float64{0.8, -0.1, -0.1}, 0.4, hullMixed},
+	}
+	for _, test := range tests {
+		if state := hull.classify(test.corner, test.side); state != test.want {
+			t.Errorf("%v: got state %v, want %v", test.name, state, test.want)
+		}
+	}
+}
+
+func TestVisualHullContains(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	hull := newSphereHull()
+
+	tests := []struct {
+		name  string
+		point []float64
+		want  bool
+	}{
+		{"center", []float64{0, 0, 0, 1}, true},
+		{"inside the sphere", []float64{0.3, -0.5, 0.4, 1}, true},
+		{"scaled homogeneous", []float64{0.6, -1, 0.8, 2}, true},
+		{"corner of the box", []float64{1.4, 1.4, 1.4, 1}, false},
+		{"outside the box", []float64{3, 3, 0, 1}, false},
+		{"at infinity", []float64{1, 0, 0, 0}, false},
+		{"not a number", []float64{math.NaN(), 0, 0, 1}, false},
+	}
+	for _, test := range tests {
+		if got := hull.Contains(mat.NewVecDense(4, test.point)); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVisualHullMesh(t *testing.T) {
+	defer useImagesManager(imgsManager)
+	hull := newSphereHull()
+	result := hull.Mesh()
+	if len(result.Faces) == 0 {
+		t.Fatal("the mesh is empty")
+	}
+	checkClosedMesh(t, result)
+
+	// the hull contains the sphere up to the size of a pixel, vertices lie
+	// between inside and outside nodes of the finest grid
+	step := hull.CellSide / float64(int(1)<<uint(hull.Depth))
+	pixelSize := 4.0 / sceneFocal
+	for _, vertex := range result.Vertices {
+		radius := math.Sqrt(dot3(vertex, vertex))
+		if radius < 1-step-pixelSize || radius > 1.5 {
+			t.Fatalf("vertex %v at radius %v", vertex, radius)
+		}
+	}
+
+	// the same mesh as the one of the dense grid
+	cellsPerSide := 1 << uint(hull.Depth)
+	var size [3]int
+	var origin [3]float64
+	for i := 0; i < 3; i++ {
+		size[i] = hull.Dims[i]*cellsPerSide + 3
+		origin[i] = hull.Min[i] - step
+	}
+	grid := mesh.NewGrid(size, origin, step)
+	point := mat.NewVecDense(4, nil)
+	point.SetVec(3, 1)
+	for z := 1; z < size[2]-1; z++ {
+		for y := 1; y < size[1]-1; y++ {
+			for x := 1; x < size[0]-1; x++ {
+				pos := grid.Position(x, y, z)
+				for i, n := range [3]int{x, y, z} {
+					if n == size[i]-2 {
+						pos[i] -= step * 1e-6
+					}
+				}
+				point.SetVec(0, pos[0])
+				point.SetVec(1, pos[1])
+				point.SetVec(2, pos[2])
+				if hull.Contains(point) {
+					grid.Set(x, y, z, 1)
+				}
+			}
+		}
+	}
+	dense := mesh.ExtractIsoSurface(grid, 0.5)
+	if !reflect.DeepEqual(dense.Vertices, result.Vertices) ||
+		!reflect.DeepEqual(dense.Faces, result.Faces) {
+		t.Errorf("got %v vertices and %v faces, the dense grid gives %v and %v",
+			len(result.Vertices), len(result.Faces), len(dense.Vertices), len(dense.Faces))
+	}
+}
+
+// checkClosedMesh : Checks that every edge of the mesh is shared by exactly
+// two faces that traverse it in opposite directions
+func checkClosedMesh(t *testing.T, result *mesh.Mesh) {
+	t.Helper()
+	edges := make(map[[2]int]int)
+	for _, face := range result.Faces {
+		for i := 0; i < 3; i++ {
+			edges[[2]int{face[i], face[(i+1)%3]}]++
+		}
+	}
+	for edge, count := range edges {
+		if count != 1 || edges[[2]int{edge[1], edge[0]}] != 1 {
+			t.Fatalf("edge %v is used %v times and its opposite %v times",
+				edge, count, edges[[2]int{edge[1], edge[0]}])
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package featdetect
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"pmvs/image"
+)
+
+const (
+	featuresMagic   = "PMVSFEAT"
+	featuresVersion = uint32(1)
+	// detectorVersion : Should be increased whenever the detectors change
+	// in a way the settings below don't capture, to invalidate the caches
+	detectorVersion = 1
+)
+
+var (
+	errNotFeatures       = errors.New("Error! File is not a features file")
+	errFeaturesVersion   = errors.New("Error! Features file version is not supported")
+	errFeaturesStale     = errors.New("Error! Features file was computed from other data")
+	errFeaturesMalformed = errors.New("Error! Features file is malformed")
+)
+
+// DetectFeaturesCached : Same as DetectFeatures, except that the features
+// are loaded from cacheDir when they were already detected in the same image
+// and mask with the same detector settings, and saved there otherwise. The
+// features are valid even if saving them fails, the error is only reported
+func DetectFeaturesCached(img, mask *image.CHWImage, cacheDir string) ([][]*Feature, error) {
+	return detectFeaturesCached(img, mask, cacheDir, func() [][]*Feature {
+		return DetectFeatures(img, mask)
+	})
+}
+
+// DetectFeaturesGrayCached : Same as DetectFeaturesCached, gray being
+// image.Grayscale of the image, as held by core.Photo
+func DetectFeaturesGrayCached(img, gray, mask *image.CHWImage, cacheDir string) ([][]*Feature, error) {
+	return detectFeaturesCached(img, mask, cacheDir, func() [][]*Feature {
+		return DetectFeaturesGray(img, gray, mask)
+	})
+}
+
+// detectFeaturesCached : Loads the features of the image from cacheDir, or
+// detects them and saves them there
+func detectFeaturesCached(img, mask *image.CHWImage, cacheDir string,
+	detect func() [][]*Feature) ([][]*Feature, error) {
+
+	key := cacheKey(img, mask)
+	path := filepath.Join(cacheDir, hex.EncodeToString(key[:])+".feat")
+	if features, err := loadFeatures(path, key); err == nil {
+		return features, nil
+	}
+	features := detect()
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return features, err
+	}
+	return features, saveFeatures(path, key, features)
+}
+
+// cacheKey : Hashes the content of the image and the mask along with the
+// detector settings
+func cacheKey(img, mask *image.CHWImage) (key [sha256.Size]byte) {
+	hash := sha256.New()
+	fmt.Fprintln(hash, detectorVersion, gridSize, featPerGridCell,
+		initialSigma, sigmaStep, octaveSize, k, harrisSigma)
+	buf := make([]byte, 4)
+	for _, data := range []*image.CHWImage{img, mask} {
+		fmt.Fprintln(hash, data.Width, data.Height, data.Channel)
+		for _, val := range data.Data {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(val))
+			hash.Write(buf)
+		}
+	}
+	copy(key[:], hash.Sum(nil))
+	return
+}
+
+// saveFeatures : Writes the features in the binary format, a header holding
+// the magic, the version and the cache key, then for every feature type the
+// number of features followed by x and y as int32 and the response as
+// float64, all in little endian. The file is written next to path then
+// renamed so that readers never see a partial file
+func saveFeatures(path string, key [sha256.Size]byte, features [][]*Feature) (err error) {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(file)
+	writer.WriteString(featuresMagic)
+	binary.Write(writer, binary.LittleEndian, featuresVersion)
+	writer.Write(key[:])
+	binary.Write(writer, binary.LittleEndian, uint32(len(features)))
+	for _, featPool := range features {
+		binary.Write(writer, binary.LittleEndian, uint32(len(featPool)))
+		for _, feat := range featPool {
+			binary.Write(writer, binary.LittleEndian, [2]int32{int32(feat.X), int32(feat.Y)})
+			binary.Write(writer, binary.LittleEndian, feat.Response)
+		}
+	}
+	// bufio keeps the first error, Flush returns it
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadFeatures : Reads features written by saveFeatures, checking that they
+// were computed for key
+func loadFeatures(path string, key [sha256.Size]byte) (features [][]*Feature, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	magic := make([]byte, len(featuresMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != featuresMagic {
+		return nil, errNotFeatures
+	}
+	var version uint32
+	if err = binary.Read(reader, binary.LittleEndian, &version); err != nil {
+		return
+	}
+	if version != featuresVersion {
+		return nil, errFeaturesVersion
+	}
+	var fileKey [sha256.Size]byte
+	if _, err = io.ReadFull(reader, fileKey[:]); err != nil {
+		return
+	}
+	if fileKey != key {
+		return nil, errFeaturesStale
+	}
+
+	var numTypes uint32
+	if err = binary.Read(reader, binary.LittleEndian, &numTypes); err != nil {
+		return
+	}
+	if numTypes != uint32(Count) {
+		return nil, errFeaturesMalformed
+	}
+	features = make([][]*Feature, numTypes)
+	for featType := range features {
+		var num uint32
+		if err = binary.Read(reader, binary.LittleEndian, &num); err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < num; i++ {
+			var pos [2]int32
+			var response float64
+			if err = binary.Read(reader, binary.LittleEndian, &pos); err != nil {
+				return nil, err
+			}
+			if err = binary.Read(reader, binary.LittleEndian, &response); err != nil {
+				return nil, err
+			}
+			features[featType] = append(features[featType],
+				NewFeature(int(pos[0]), int(pos[1]), response, FeatType(featType)))
+		}
+	}
+	return
+}
+
+// ExportFeaturesText : Writes the features in a human readable format, one
+// feature per line as "type x y response", for debugging
+func ExportFeaturesText(path string, features [][]*Feature) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintln(writer, "# type x y response")
+	for _, featPool := range features {
+		for _, feat := range featPool {
+			fmt.Fprintln(writer, featTypeName(feat.Type), feat.X, feat.Y, feat.Response)
+		}
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+func featTypeName(featType FeatType) string {
+	switch featType {
+	case DoG:
+		return "DoG"
+	case Harris:
+		return "Harris"
+	}
+	return fmt.Sprint(int(featType))
+}
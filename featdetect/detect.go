@@ -9,8 +9,14 @@ const (
 
 // DetectFeatures : Detects DoG and Harris features from the image
 func DetectFeatures(img, mask *image.CHWImage) [][]*Feature {
+	return DetectFeaturesGray(img, image.Grayscale(img), mask)
+}
+
+// DetectFeaturesGray : Same as DetectFeatures, gray being image.Grayscale
+// of the image, as held by core.Photo
+func DetectFeaturesGray(img, gray, mask *image.CHWImage) [][]*Feature {
 	features := make([][]*Feature, 2, 2)
 	features[0] = detectDogFeatures(img, mask)
-	features[1] = detectHarrisFeatures(img, mask)
+	features[1] = detectHarrisFeatures(gray, mask)
 	return features
 }
@@ -10,11 +10,11 @@ const (
 	harrisSigma = 3
 )
 
-func detectHarrisFeatures(img, mask *image.CHWImage) []*Feature {
-	responseMap := image.HarrisCorner(image.Grayscale(img), harrisSigma, k)
+func detectHarrisFeatures(gray, mask *image.CHWImage) []*Feature {
+	responseMap := image.HarrisCorner(gray, harrisSigma, k)
 
-	width := img.Width
-	height := img.Height
+	width := gray.Width
+	height := gray.Height
 	gridColsNum := int((width + gridSize - 1) / gridSize)
 	gridRowsNum := int((height + gridSize - 1) / gridSize)
 
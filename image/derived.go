@@ -0,0 +1,97 @@
+package image
+
+// Luminance : Transforms an RGB image into its luminance with the Rec. 601
+// weights, single channel images are copied
+func Luminance(image *CHWImage) *CHWImage {
+	gray := NewImage(image.Height, image.Width, 1)
+	imageSize := image.Height * image.Width
+	if image.Channel < 3 {
+		copy(gray.Data, image.Data[:imageSize])
+		return gray
+	}
+	for i := 0; i < imageSize; i++ {
+		gray.Data[i] = 0.299*image.Data[i] + 0.587*image.Data[i+imageSize] +
+			0.114*image.Data[i+2*imageSize]
+	}
+	return gray
+}
+
+// Gradients : Returns the derivatives of the image along x and y by central
+// differences
+func Gradients(image *CHWImage) (gradX, gradY *CHWImage) {
+	dFilter := []float32{-0.5, 0, 0.5}
+	return ConvolveX(image, dFilter), ConvolveY(image, dFilter)
+}
+
+// Integral : Summed area tables of a channel of an image and of its square,
+// giving the sum and the variance of any window in constant time
+type Integral struct {
+	Width  int
+	Height int
+	// (Height + 1) x (Width + 1) tables, the first row and column are 0
+	sum   []float64
+	sqSum []float64
+}
+
+// NewIntegral : Creates the summed area tables of channel c of the image
+func NewIntegral(image *CHWImage, c int) *Integral {
+	integral := new(Integral)
+	integral.Width, integral.Height = image.Width, image.Height
+	stride := image.Width + 1
+	integral.sum = make([]float64, (image.Height+1)*stride)
+	integral.sqSum = make([]float64, (image.Height+1)*stride)
+	for y := 0; y < image.Height; y++ {
+		var rowSum, rowSqSum float64
+		for x := 0; x < image.Width; x++ {
+			val := float64(image.At(y, x, c))
+			rowSum += val
+			rowSqSum += val * val
+			index := (y+1)*stride + x + 1
+			integral.sum[index] = integral.sum[index-stride] + rowSum
+			integral.sqSum[index] = integral.sqSum[index-stride] + rowSqSum
+		}
+	}
+	return integral
+}
+
+// Sum : Returns the number of pixels, the sum and the sum of squares of the
+// window from (y0, x0) to (y1, x1) included, clipped to the image
+func (integral *Integral) Sum(y0, x0, y1, x1 int) (count int, sum, sqSum float64) {
+	x0, y0 = clamp(x0, 0, integral.Width), clamp(y0, 0, integral.Height)
+	x1, y1 = clamp(x1+1, 0, integral.Width), clamp(y1+1, 0, integral.Height)
+	if x1 <= x0 || y1 <= y0 {
+		return
+	}
+	stride := integral.Width + 1
+	count = (x1 - x0) * (y1 - y0)
+	box := func(table []float64) float64 {
+		return table[y1*stride+x1] - table[y0*stride+x1] -
+			table[y1*stride+x0] + table[y0*stride+x0]
+	}
+	return count, box(integral.sum), box(integral.sqSum)
+}
+
+// Variance : Returns the variance of the window from (y0, x0) to (y1, x1)
+// included, clipped to the image, windows outside the image have none
+func (integral *Integral) Variance(y0, x0, y1, x1 int) float64 {
+	count, sum, sqSum := integral.Sum(y0, x0, y1, x1)
+	if count == 0 {
+		return 0
+	}
+	mean := sum / float64(count)
+	// rounding can make it slightly negative
+	if variance := sqSum/float64(count) - mean*mean; variance > 0 {
+		return variance
+	}
+	return 0
+}
+
+func clamp(val, low, high int) int {
+	if val < low {
+		return low
+	}
+	if val > high {
+		return high
+	}
+	return val
+}
@@ -84,3 +84,23 @@ func NewImage(height, width, channel int) *CHWImage {
 	image.Data = make([]float32, size, size)
 	return image
 }
+
+// Bilinear : Returns the value at the real coordinates (y, x, c) using
+// bilinear interpolation, points outside the image are 0
+func (image *CHWImage) Bilinear(y, x float64, c int) float32 {
+	if x < 0 || y < 0 || x > float64(image.Width-1) || y > float64(image.Height-1) {
+		return 0
+	}
+	x0, y0 := int(x), int(y)
+	x1, y1 := x0+1, y0+1
+	if x1 >= image.Width {
+		x1 = x0
+	}
+	if y1 >= image.Height {
+		y1 = y0
+	}
+	fx, fy := float32(x-float64(x0)), float32(y-float64(y0))
+	top := image.At(y0, x0, c)*(1-fx) + image.At(y0, x1, c)*fx
+	bottom := image.At(y1, x0, c)*(1-fx) + image.At(y1, x1, c)*fx
+	return top*(1-fy) + bottom*fy
+}
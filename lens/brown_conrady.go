@@ -0,0 +1,23 @@
+package lens
+
+// BrownConrady : Radial (K1, K2, K3) and tangential (P1, P2) distortion as
+// used by OpenCV
+type BrownConrady struct {
+	K1, K2, K3 float64
+	P1, P2     float64
+}
+
+// IsZero : Returns whether the model doesn't distort at all
+func (model BrownConrady) IsZero() bool {
+	return model.K1 == 0 && model.K2 == 0 && model.K3 == 0 &&
+		model.P1 == 0 && model.P2 == 0
+}
+
+// Distort : Maps undistorted normalized coordinates to distorted ones
+func (model BrownConrady) Distort(x, y float64) (float64, float64) {
+	r2 := x*x + y*y
+	radial := 1 + r2*(model.K1+r2*(model.K2+r2*model.K3))
+	xd := x*radial + 2*model.P1*x*y + model.P2*(r2+2*x*x)
+	yd := y*radial + model.P1*(r2+2*y*y) + 2*model.P2*x*y
+	return xd, yd
+}
@@ -0,0 +1,24 @@
+package lens
+
+import "math"
+
+// Fisheye : The equidistant fisheye model of OpenCV, the angle of a ray
+// with the optical axis theta is mapped to a distance from the principal
+// point of theta * (1 + K1 theta^2 + K2 theta^4 + K3 theta^6 + K4 theta^8)
+type Fisheye struct {
+	K1, K2, K3, K4 float64
+}
+
+// Distort : Maps undistorted normalized coordinates to distorted ones
+func (model Fisheye) Distort(x, y float64) (float64, float64) {
+	r := math.Sqrt(x*x + y*y)
+	if r == 0 {
+		return x, y
+	}
+	theta := math.Atan(r)
+	theta2 := theta * theta
+	thetaD := theta * (1 + theta2*(model.K1+theta2*(model.K2+
+		theta2*(model.K3+theta2*model.K4))))
+	scale := thetaD / r
+	return x * scale, y * scale
+}
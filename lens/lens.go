@@ -0,0 +1,51 @@
+package lens
+
+import "pmvs/image"
+
+// Model : A lens distortion model acting on normalized image coordinates,
+// that is pixel coordinates with the principal point subtracted and
+// divided by the focal length
+type Model interface {
+	// Distort : Maps undistorted normalized coordinates to distorted ones
+	Distort(x, y float64) (float64, float64)
+}
+
+// Intrinsics : The pinhole parameters of a camera in pixels
+type Intrinsics struct {
+	Fx, Fy float64
+	Cx, Cy float64
+}
+
+// Undistort : Resamples the image and its mask so that they follow the
+// pinhole model with the same intrinsics. Every output pixel is looked up at
+// its distorted position in the input using bilinear interpolation, pixels
+// whose distorted position falls outside the input are masked. The mask may
+// be nil, in which case the returned mask is nil
+func Undistort(img, mask *image.CHWImage, intrinsics Intrinsics,
+	model Model) (*image.CHWImage, *image.CHWImage) {
+
+	result := image.NewImage(img.Height, img.Width, img.Channel)
+	var resultMask *image.CHWImage
+	if mask != nil {
+		resultMask = image.NewImage(mask.Height, mask.Width, 1)
+	}
+	maxX, maxY := float64(img.Width-1), float64(img.Height-1)
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			xd, yd := model.Distort((float64(x)-intrinsics.Cx)/intrinsics.Fx,
+				(float64(y)-intrinsics.Cy)/intrinsics.Fy)
+			srcX, srcY := xd*intrinsics.Fx+intrinsics.Cx, yd*intrinsics.Fy+intrinsics.Cy
+			if srcX < 0 || srcY < 0 || srcX > maxX || srcY > maxY {
+				continue
+			}
+			for c := 0; c < img.Channel; c++ {
+				result.Set(y, x, c, img.Bilinear(srcY, srcX, c))
+			}
+			// the mask stays binary
+			if mask != nil && mask.Bilinear(srcY, srcX, 0) >= 0.5 {
+				resultMask.Set(y, x, 0, 1)
+			}
+		}
+	}
+	return result, resultMask
+}
@@ -0,0 +1,125 @@
+package lens
+
+import (
+	"math"
+	"pmvs/image"
+	"testing"
+)
+
+const (
+	testWidth  = 64
+	testHeight = 48
+	testFocal  = 50
+)
+
+var testIntrinsics = Intrinsics{
+	Fx: testFocal, Fy: testFocal, Cx: testWidth/2 - 0.5, Cy: testHeight/2 - 0.5,
+}
+
+// pattern : Smooth value of the scene at undistorted normalized coordinates
+func pattern(x, y float64) float32 {
+	return float32(0.5 + 0.25*math.Sin(3*x) + 0.25*math.Cos(2*y))
+}
+
+// undistortPoint : Inverts model.Distort with fixed point iterations
+func undistortPoint(model Model, xd, yd float64) (x, y float64) {
+	x, y = xd, yd
+	for i := 0; i < 200; i++ {
+		dx, dy := model.Distort(x, y)
+		x, y = x+xd-dx, y+yd-dy
+	}
+	return
+}
+
+// distortedImage : Returns the image of the pattern taken through the lens
+func distortedImage(model Model) *image.CHWImage {
+	img := image.NewImage(testHeight, testWidth, 1)
+	for y := 0; y < testHeight; y++ {
+		for x := 0; x < testWidth; x++ {
+			ux, uy := undistortPoint(model, (float64(x)-testIntrinsics.Cx)/testFocal,
+				(float64(y)-testIntrinsics.Cy)/testFocal)
+			img.Set(y, x, 0, pattern(ux, uy))
+		}
+	}
+	return img
+}
+
+func TestUndistort(t *testing.T) {
+	tests := []struct {
+		name  string
+		model Model
+		// whether the corners of the undistorted image have no data
+		cornersMasked bool
+	}{
+		{"barrel", BrownConrady{K1: -0.2, K2: 0.05, P1: 0.01, P2: -0.005}, false},
+		{"pincushion", BrownConrady{K1: 0.3, K3: 0.1}, true},
+		{"fisheye", Fisheye{K1: -0.05, K2: 0.01, K3: 0.002, K4: -0.001}, false},
+		{"measurement radial", MeasurementRadial{K: -0.15}, true},
+	}
+	for _, test := range tests {
+		mask := image.NewImage(testHeight, testWidth, 1)
+		for i := range mask.Data {
+			mask.Data[i] = 1
+		}
+		result, resultMask := Undistort(distortedImage(test.model), mask,
+			testIntrinsics, test.model)
+
+		var maxError float64
+		for y := 0; y < testHeight; y++ {
+			for x := 0; x < testWidth; x++ {
+				if resultMask.At(y, x, 0) == 0 {
+					continue
+				}
+				want := pattern((float64(x)-testIntrinsics.Cx)/testFocal,
+					(float64(y)-testIntrinsics.Cy)/testFocal)
+				maxError = math.Max(maxError, math.Abs(float64(result.At(y, x, 0)-want)))
+			}
+		}
+		if maxError > 2e-3 {
+			t.Errorf("%v: the undistorted image is %v away from the pattern", test.name, maxError)
+		}
+		if resultMask.At(testHeight/2, testWidth/2, 0) == 0 {
+			t.Errorf("%v: the center is masked", test.name)
+		}
+		if masked := resultMask.At(0, 0, 0) == 0; masked != test.cornersMasked {
+			t.Errorf("%v: got corner masked %v, want %v", test.name, masked, test.cornersMasked)
+		}
+	}
+}
+
+func TestDistort(t *testing.T) {
+	tests := []struct {
+		name  string
+		model Model
+		point [2]float64
+		want  [2]float64
+	}{
+		{"no distortion", BrownConrady{}, [2]float64{0.3, -0.2}, [2]float64{0.3, -0.2}},
+		// r^2 = 0.25, radial = 1 + 0.1 * 0.25
+		{"radial", BrownConrady{K1: 0.1}, [2]float64{0.5, 0}, [2]float64{0.5125, 0}},
+		// the tangential terms at (0.5, 0) are P2 (r^2 + 2 x^2) along x and
+		// P1 r^2 along y
+		{"tangential", BrownConrady{P1: 0.01, P2: 0.02}, [2]float64{0.5, 0},
+			[2]float64{0.5 + 0.02*0.75, 0.01 * 0.25}},
+		// the angle of the ray is 45 degrees
+		{"equidistant", Fisheye{}, [2]float64{1, 0}, [2]float64{math.Pi / 4, 0}},
+		{"fisheye", Fisheye{K1: 0.1}, [2]float64{0, 1},
+			[2]float64{0, math.Pi / 4 * (1 + 0.1*math.Pi*math.Pi/16)}},
+		{"center", Fisheye{K1: 0.1}, [2]float64{0, 0}, [2]float64{0, 0}},
+	}
+	for _, test := range tests {
+		x, y := test.model.Distort(test.point[0], test.point[1])
+		if math.Hypot(x-test.want[0], y-test.want[1]) > 1e-12 {
+			t.Errorf("%v: got (%v, %v), want %v", test.name, x, y, test.want)
+		}
+	}
+
+	// the measurements follow undistorted = distorted * (1 + K r^2)
+	model := MeasurementRadial{K: -0.15}
+	xd, yd := model.Distort(0.4, -0.3)
+	scale := 1 + model.K*(xd*xd+yd*yd)
+	if math.Hypot(xd*scale-0.4, yd*scale+0.3) > 1e-9 {
+		t.Errorf("(0.4, -0.3) is distorted to (%v, %v) which undistorts to (%v, %v)",
+			xd, yd, xd*scale, yd*scale)
+	}
+}
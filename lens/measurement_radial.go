@@ -0,0 +1,20 @@
+package lens
+
+// MeasurementRadial : Radial distortion defined on the distorted
+// measurements as in VisualSfM, undistorted = distorted * (1 + K r^2)
+// where r is the distance of the distorted point to the principal point in
+// normalized coordinates. VisualSfM measures r in pixels, its coefficient
+// should be multiplied by fx * fy
+type MeasurementRadial struct {
+	K float64
+}
+
+// Distort : Inverts the distortion with fixed point iterations
+func (model MeasurementRadial) Distort(x, y float64) (float64, float64) {
+	xd, yd := x, y
+	for i := 0; i < 20; i++ {
+		scale := 1 + model.K*(xd*xd+yd*yd)
+		xd, yd = x/scale, y/scale
+	}
+	return xd, yd
+}
@@ -0,0 +1,165 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"pmvs/image"
+	"pmvs/lens"
+	"strings"
+)
+
+// LoadBundler : Loads the cameras and points of a Bundler "bundle.out" file
+// along with the images listed, one per line, in listPath. Image paths are
+// relative to the directory of the list. Cameras that Bundler couldn't
+// register (zero focal length) are skipped. If undistort is true the
+// images are resampled to remove the radial distortion, otherwise it is
+// ignored. Bundler has no silhouettes, so the returned masks only mask the
+// pixels that undistortion leaves without data. Each matrix in 'mats' is
+// in row-major, and points views index 'images'. If ctx is cancelled the
+// images loaded so far are returned with its error
+func LoadBundler(
+	ctx context.Context,
+	bundlePath string,
+	listPath string,
+	undistort bool,
+) (images, silhouettes []*image.CHWImage, mats [][]float64,
+	points *SparsePoints, err error) {
+
+	imagePaths, err := readImageList(listPath)
+	if err != nil {
+		return
+	}
+	content, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return
+	}
+	// drop the header comment
+	lines := strings.Split(string(content), "\n")
+	for len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(lines[0]), "#") {
+		lines = lines[1:]
+	}
+	reader := newTokenReader(strings.Join(lines, "\n"))
+
+	numCameras, err := reader.int()
+	if err != nil {
+		return
+	}
+	numPoints, err := reader.int()
+	if err != nil {
+		return
+	}
+	if numCameras > len(imagePaths) {
+		err = errMalformedFile
+		return
+	}
+
+	// indices maps camera indices to indices of the loaded images
+	indices := make([]int, numCameras)
+	for i := 0; i < numCameras; i++ {
+		// focal length, k1, k2, rotation and translation
+		var params [15]float64
+		if err = reader.floats(params[:]); err != nil {
+			return
+		}
+		focal, k1, k2 := params[0], params[1], params[2]
+		if focal == 0 {
+			indices[i] = -1
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var imageData *image.CHWImage
+		imageData, err = loadImageFile(imagePaths[i])
+		if err != nil {
+			return
+		}
+		cx := float64(imageData.Width)/2 - 0.5
+		cy := float64(imageData.Height)/2 - 0.5
+		mask := fullMask(imageData.Height, imageData.Width)
+		distortion := lens.BrownConrady{K1: k1, K2: k2}
+		if undistort && !distortion.IsZero() {
+			imageData, mask = lens.Undistort(imageData, mask,
+				lens.Intrinsics{Fx: focal, Fy: focal, Cx: cx, Cy: cy}, distortion)
+		}
+
+		var rot [9]float64
+		copy(rot[:], params[3:12])
+		var trans [3]float64
+		copy(trans[:], params[12:15])
+		indices[i] = len(images)
+		images = append(images, imageData)
+		silhouettes = append(silhouettes, mask)
+		mats = append(mats, bundlerProjectionMatrix(focal, cx, cy, rot, trans))
+	}
+
+	points = new(SparsePoints)
+	for i := 0; i < numPoints; i++ {
+		// position and color
+		var params [6]float64
+		if err = reader.floats(params[:]); err != nil {
+			return
+		}
+		var numViews int
+		if numViews, err = reader.int(); err != nil {
+			return
+		}
+		views := make([]int, 0, numViews)
+		for j := 0; j < numViews; j++ {
+			// camera index, key index, x and y
+			var view [4]float64
+			if err = reader.floats(view[:]); err != nil {
+				return
+			}
+			camera := int(view[0])
+			if camera < 0 || camera >= numCameras {
+				err = errMalformedFile
+				return
+			}
+			if indices[camera] >= 0 && !containsIndex(views, indices[camera]) {
+				views = append(views, indices[camera])
+			}
+		}
+		points.Positions = append(points.Positions, []float64{params[0], params[1], params[2]})
+		points.Colors = append(points.Colors, []float32{
+			float32(params[3]) / 255, float32(params[4]) / 255, float32(params[5]) / 255,
+		})
+		points.Views = append(points.Views, views)
+	}
+	return
+}
+
+// bundlerProjectionMatrix : Bundler cameras look down their negative z axis
+// with the y axis pointing up, the projection is flipped accordingly so that
+// points in front of the camera get a positive depth
+func bundlerProjectionMatrix(focal, cx, cy float64, rot [9]float64, trans [3]float64) []float64 {
+	k := [9]float64{
+		focal, 0, -cx,
+		0, -focal, -cy,
+		0, 0, -1,
+	}
+	return composeProjection(k, rot, trans)
+}
+
+// readImageList : Reads the image paths of a Bundler list file, each line
+// holds a path optionally followed by other values
+func readImageList(listPath string) (paths []string, err error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	dir := filepath.Dir(listPath)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, fields[0]))
+	}
+	err = scanner.Err()
+	return
+}
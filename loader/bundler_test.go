@@ -0,0 +1,61 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestLoadBundler(t *testing.T) {
+	images, masks, mats, points, err := LoadBundler(context.Background(),
+		"testdata/bundler/bundle.out", "testdata/bundler/list.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second camera has a zero focal length and is skipped
+	checkImages(t, images, masks, 0, float32(100)/255)
+	if len(mats) != 2 {
+		t.Fatalf("got %v matrices, want 2", len(mats))
+	}
+	cameras := []struct {
+		focal float64
+		rot   [9]float64
+		trans [3]float64
+	}{
+		{100, identity, [3]float64{0, 0, -3}},
+		// half a turn about the y axis
+		{80, [9]float64{-1, 0, 0, 0, 1, 0, 0, 0, -1}, [3]float64{0, 0, -3}},
+	}
+	cx, cy := 3.5, 2.5
+	for i, camera := range cameras {
+		k := [9]float64{camera.focal, 0, -cx, 0, -camera.focal, -cy, 0, 0, -1}
+		checkProjection(t, fmt.Sprint("camera ", i), mats[i], k, camera.rot, camera.trans)
+
+		// Bundler cameras look down their negative z axis with the y axis
+		// pointing up, points in front get a positive depth
+		for _, point := range points.Positions {
+			var cam [3]float64
+			for r := 0; r < 3; r++ {
+				cam[r] = camera.rot[r*3]*point[0] + camera.rot[r*3+1]*point[1] +
+					camera.rot[r*3+2]*point[2] + camera.trans[r]
+			}
+			depth := -cam[2]
+			wantX, wantY := cx+camera.focal*cam[0]/depth, cy-camera.focal*cam[1]/depth
+			var projected [3]float64
+			for r := 0; r < 3; r++ {
+				projected[r] = mats[i][r*4]*point[0] + mats[i][r*4+1]*point[1] +
+					mats[i][r*4+2]*point[2] + mats[i][r*4+3]
+			}
+			x, y := projected[0]/projected[2], projected[1]/projected[2]
+			if math.Abs(projected[2]-depth) > 1e-12 || math.Abs(x-wantX) > 1e-12 ||
+				math.Abs(y-wantY) > 1e-12 {
+				t.Errorf("camera %v sees %v at (%v, %v) and depth %v, want (%v, %v) and %v",
+					i, point, x, y, projected[2], wantX, wantY, depth)
+			}
+		}
+	}
+	// views of the skipped camera are dropped, the others are remapped
+	checkPoints(t, points, [][]float64{{0.3, 0.2, 0}, {0, 0, 0}},
+		[][]float32{{1, 0, 0}, {0, 1, 0}}, [][]int{{0, 1}, {}})
+}
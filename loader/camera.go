@@ -0,0 +1,38 @@
+package loader
+
+// intrinsicMatrix : Returns the row-major calibration matrix of a pinhole
+// camera
+func intrinsicMatrix(fx, fy, cx, cy float64) [9]float64 {
+	return [9]float64{
+		fx, 0, cx,
+		0, fy, cy,
+		0, 0, 1,
+	}
+}
+
+// quaternionToRotation : Converts a unit quaternion (w, x, y, z) to a
+// row-major rotation matrix
+func quaternionToRotation(q [4]float64) [9]float64 {
+	w, x, y, z := q[0], q[1], q[2], q[3]
+	return [9]float64{
+		1 - 2*y*y - 2*z*z, 2*x*y - 2*w*z, 2*x*z + 2*w*y,
+		2*x*y + 2*w*z, 1 - 2*x*x - 2*z*z, 2*y*z - 2*w*x,
+		2*x*z - 2*w*y, 2*y*z + 2*w*x, 1 - 2*x*x - 2*y*y,
+	}
+}
+
+// composeProjection : Returns K [R | t] in row-major
+func composeProjection(k, rot [9]float64, trans [3]float64) []float64 {
+	proj := make([]float64, 12)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for l := 0; l < 3; l++ {
+				proj[i*4+j] += k[i*3+l] * rot[l*3+j]
+			}
+		}
+		for l := 0; l < 3; l++ {
+			proj[i*4+3] += k[i*3+l] * trans[l]
+		}
+	}
+	return proj
+}
@@ -0,0 +1,486 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"pmvs/image"
+	"pmvs/lens"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// COLMAP camera model ids
+const (
+	colmapSimplePinhole = 0
+	colmapPinhole       = 1
+	colmapSimpleRadial  = 2
+	colmapRadial        = 3
+	colmapOpenCV        = 4
+)
+
+var (
+	colmapModelIDs = map[string]int{
+		"SIMPLE_PINHOLE": colmapSimplePinhole,
+		"PINHOLE":        colmapPinhole,
+		"SIMPLE_RADIAL":  colmapSimpleRadial,
+		"RADIAL":         colmapRadial,
+		"OPENCV":         colmapOpenCV,
+	}
+	colmapModelParams = map[int]int{
+		colmapSimplePinhole: 3,
+		colmapPinhole:       4,
+		colmapSimpleRadial:  4,
+		colmapRadial:        5,
+		colmapOpenCV:        8,
+	}
+
+	errNotSupportedModel = errors.New("Error! Camera model is not supported")
+	errMissingModel      = errors.New("Error! COLMAP model files not found")
+	errMalformedFile     = errors.New("Error! Malformed file")
+	errUnknownCamera     = errors.New("Error! Image refers to unknown camera")
+)
+
+// SparsePoints : The 3D points of a sparse reconstruction, Views[i] holds the
+// indices of the loaded images in which point i was observed
+type SparsePoints struct {
+	Positions [][]float64
+	Colors    [][]float32
+	Views     [][]int
+}
+
+type colmapCamera struct {
+	model  int
+	width  int
+	height int
+	params []float64
+}
+
+type colmapImage struct {
+	id       int
+	quat     [4]float64
+	trans    [3]float64
+	cameraID int
+	name     string
+}
+
+type colmapPoint struct {
+	pos      [3]float64
+	color    [3]float32
+	imageIDs []int
+}
+
+// LoadColmap : Loads a COLMAP sparse model (the cameras, images and points3D
+// files, either in text or binary format) from modelPath, and the images it
+// references from imagesPath. If undistort is true the images are resampled
+// to remove the distortion of the camera model, otherwise it is ignored.
+// COLMAP has no silhouettes, so the returned masks only mask the pixels
+// that undistortion leaves without data.
+// Each matrix in 'mats' is in row-major, and points views index 'images'.
+// If ctx is cancelled the images loaded so far are returned with its error
+func LoadColmap(
+	ctx context.Context,
+	modelPath string,
+	imagesPath string,
+	undistort bool,
+) (images, silhouettes []*image.CHWImage, mats [][]float64,
+	points *SparsePoints, err error) {
+
+	var cameras map[int]*colmapCamera
+	var colmapImages []*colmapImage
+	var colmapPoints []*colmapPoint
+
+	if fileExists(filepath.Join(modelPath, "cameras.bin")) {
+		if cameras, err = readColmapCamerasBinary(filepath.Join(modelPath, "cameras.bin")); err != nil {
+			return
+		}
+		if colmapImages, err = readColmapImagesBinary(filepath.Join(modelPath, "images.bin")); err != nil {
+			return
+		}
+		colmapPoints, err = readColmapPointsBinary(filepath.Join(modelPath, "points3D.bin"))
+	} else if fileExists(filepath.Join(modelPath, "cameras.txt")) {
+		if cameras, err = readColmapCamerasText(filepath.Join(modelPath, "cameras.txt")); err != nil {
+			return
+		}
+		if colmapImages, err = readColmapImagesText(filepath.Join(modelPath, "images.txt")); err != nil {
+			return
+		}
+		colmapPoints, err = readColmapPointsText(filepath.Join(modelPath, "points3D.txt"))
+	} else {
+		err = errMissingModel
+	}
+	if err != nil {
+		return
+	}
+
+	sort.Slice(colmapImages, func(i, j int) bool {
+		return colmapImages[i].id < colmapImages[j].id
+	})
+	indices := make(map[int]int, len(colmapImages))
+	for _, colmapImg := range colmapImages {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		camera := cameras[colmapImg.cameraID]
+		if camera == nil {
+			err = errUnknownCamera
+			return
+		}
+		var imageData *image.CHWImage
+		imageData, err = loadImageFile(filepath.Join(imagesPath, colmapImg.name))
+		if err != nil {
+			return
+		}
+		mask := fullMask(imageData.Height, imageData.Width)
+		fx, fy, cx, cy, distortion := camera.intrinsics()
+		if undistort && !distortion.IsZero() {
+			imageData, mask = lens.Undistort(imageData, mask,
+				lens.Intrinsics{Fx: fx, Fy: fy, Cx: cx, Cy: cy}, distortion)
+		}
+		indices[colmapImg.id] = len(images)
+		images = append(images, imageData)
+		silhouettes = append(silhouettes, mask)
+		mats = append(mats, composeProjection(intrinsicMatrix(fx, fy, cx, cy),
+			quaternionToRotation(colmapImg.quat), colmapImg.trans))
+	}
+
+	points = new(SparsePoints)
+	for _, point := range colmapPoints {
+		views := make([]int, 0, len(point.imageIDs))
+		for _, imageID := range point.imageIDs {
+			if index, ok := indices[imageID]; ok && !containsIndex(views, index) {
+				views = append(views, index)
+			}
+		}
+		points.Positions = append(points.Positions,
+			[]float64{point.pos[0], point.pos[1], point.pos[2]})
+		points.Colors = append(points.Colors,
+			[]float32{point.color[0], point.color[1], point.color[2]})
+		points.Views = append(points.Views, views)
+	}
+	return
+}
+
+// intrinsics : Returns the pinhole parameters and distortion of the camera.
+// COLMAP puts the center of the top left pixel at (0.5, 0.5) while pixel
+// centers here are at integer coordinates
+func (camera *colmapCamera) intrinsics() (fx, fy, cx, cy float64, distortion lens.BrownConrady) {
+	p := camera.params
+	switch camera.model {
+	case colmapSimplePinhole:
+		fx, fy, cx, cy = p[0], p[0], p[1], p[2]
+	case colmapPinhole:
+		fx, fy, cx, cy = p[0], p[1], p[2], p[3]
+	case colmapSimpleRadial:
+		fx, fy, cx, cy = p[0], p[0], p[1], p[2]
+		distortion.K1 = p[3]
+	case colmapRadial:
+		fx, fy, cx, cy = p[0], p[0], p[1], p[2]
+		distortion.K1, distortion.K2 = p[3], p[4]
+	case colmapOpenCV:
+		fx, fy, cx, cy = p[0], p[1], p[2], p[3]
+		distortion.K1, distortion.K2 = p[4], p[5]
+		distortion.P1, distortion.P2 = p[6], p[7]
+	}
+	cx -= 0.5
+	cy -= 0.5
+	return
+}
+
+func readColmapCamerasText(path string) (cameras map[int]*colmapCamera, err error) {
+	cameras = make(map[int]*colmapCamera)
+	err = readDataLines(path, false, func(fields []string) error {
+		if len(fields) < 4 {
+			return errMalformedFile
+		}
+		camera := new(colmapCamera)
+		model, ok := colmapModelIDs[fields[1]]
+		if !ok {
+			return errNotSupportedModel
+		}
+		camera.model = model
+		nums, err := parseInts(fields[0], fields[2], fields[3])
+		if err != nil {
+			return err
+		}
+		camera.width, camera.height = nums[1], nums[2]
+		if camera.params, err = parseFloats(fields[4:]...); err != nil {
+			return err
+		}
+		if len(camera.params) != colmapModelParams[model] {
+			return errMalformedFile
+		}
+		cameras[nums[0]] = camera
+		return nil
+	})
+	return
+}
+
+func readColmapImagesText(path string) (images []*colmapImage, err error) {
+	// every image takes two lines, the second lists its 2D points
+	pointsLine := false
+	err = readDataLines(path, true, func(fields []string) error {
+		if pointsLine {
+			pointsLine = false
+			return nil
+		}
+		pointsLine = true
+		if len(fields) < 10 {
+			return errMalformedFile
+		}
+		colmapImg := new(colmapImage)
+		nums, err := parseFloats(fields[1:8]...)
+		if err != nil {
+			return err
+		}
+		copy(colmapImg.quat[:], nums[:4])
+		copy(colmapImg.trans[:], nums[4:])
+		ids, err := parseInts(fields[0], fields[8])
+		if err != nil {
+			return err
+		}
+		colmapImg.id, colmapImg.cameraID = ids[0], ids[1]
+		colmapImg.name = strings.Join(fields[9:], " ")
+		images = append(images, colmapImg)
+		return nil
+	})
+	return
+}
+
+func readColmapPointsText(path string) (points []*colmapPoint, err error) {
+	err = readDataLines(path, false, func(fields []string) error {
+		if len(fields) < 8 || (len(fields)-8)%2 != 0 {
+			return errMalformedFile
+		}
+		point := new(colmapPoint)
+		nums, err := parseFloats(fields[1:7]...)
+		if err != nil {
+			return err
+		}
+		copy(point.pos[:], nums[:3])
+		for i := 0; i < 3; i++ {
+			point.color[i] = float32(nums[3+i]) / 255
+		}
+		for i := 8; i < len(fields); i += 2 {
+			imageID, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return err
+			}
+			point.imageIDs = append(point.imageIDs, imageID)
+		}
+		points = append(points, point)
+		return nil
+	})
+	return
+}
+
+func readColmapCamerasBinary(path string) (cameras map[int]*colmapCamera, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var num uint64
+	if err = binary.Read(reader, binary.LittleEndian, &num); err != nil {
+		return
+	}
+	cameras = make(map[int]*colmapCamera)
+	for i := uint64(0); i < num; i++ {
+		var header struct {
+			ID     uint32
+			Model  int32
+			Width  uint64
+			Height uint64
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &header); err != nil {
+			return
+		}
+		numParams, ok := colmapModelParams[int(header.Model)]
+		if !ok {
+			err = errNotSupportedModel
+			return
+		}
+		camera := new(colmapCamera)
+		camera.model = int(header.Model)
+		camera.width, camera.height = int(header.Width), int(header.Height)
+		camera.params = make([]float64, numParams)
+		if err = binary.Read(reader, binary.LittleEndian, camera.params); err != nil {
+			return
+		}
+		cameras[int(header.ID)] = camera
+	}
+	return
+}
+
+func readColmapImagesBinary(path string) (images []*colmapImage, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var num uint64
+	if err = binary.Read(reader, binary.LittleEndian, &num); err != nil {
+		return
+	}
+	for i := uint64(0); i < num; i++ {
+		var header struct {
+			ID       uint32
+			Quat     [4]float64
+			Trans    [3]float64
+			CameraID uint32
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &header); err != nil {
+			return
+		}
+		colmapImg := new(colmapImage)
+		colmapImg.id, colmapImg.cameraID = int(header.ID), int(header.CameraID)
+		colmapImg.quat, colmapImg.trans = header.Quat, header.Trans
+		if colmapImg.name, err = reader.ReadString(0); err != nil {
+			return
+		}
+		colmapImg.name = strings.TrimSuffix(colmapImg.name, "\x00")
+
+		// skip the 2D points: x, y and the id of their 3D point
+		var numPoints uint64
+		if err = binary.Read(reader, binary.LittleEndian, &numPoints); err != nil {
+			return
+		}
+		if _, err = reader.Discard(int(numPoints) * 24); err != nil {
+			return
+		}
+		images = append(images, colmapImg)
+	}
+	return
+}
+
+func readColmapPointsBinary(path string) (points []*colmapPoint, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var num uint64
+	if err = binary.Read(reader, binary.LittleEndian, &num); err != nil {
+		return
+	}
+	for i := uint64(0); i < num; i++ {
+		var header struct {
+			ID          uint64
+			Pos         [3]float64
+			Color       [3]uint8
+			Error       float64
+			TrackLength uint64
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &header); err != nil {
+			return
+		}
+		track := make([]uint32, 2*header.TrackLength)
+		if err = binary.Read(reader, binary.LittleEndian, track); err != nil {
+			return
+		}
+		point := new(colmapPoint)
+		point.pos = header.Pos
+		for c := 0; c < 3; c++ {
+			point.color[c] = float32(header.Color[c]) / 255
+		}
+		for j := 0; j < len(track); j += 2 {
+			point.imageIDs = append(point.imageIDs, int(track[j]))
+		}
+		points = append(points, point)
+	}
+	return
+}
+
+// readDataLines : Calls handle with the fields of every line of a text file
+// that isn't a comment, empty lines are skipped unless keepEmpty is set
+func readDataLines(path string, keepEmpty bool, handle func(fields []string) error) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, errRead := reader.ReadString('\n')
+		if errRead != nil && errRead != io.EOF {
+			return errRead
+		}
+		line = strings.TrimSpace(line)
+		last := errRead == io.EOF
+		if (line != "" || (keepEmpty && !last)) && !strings.HasPrefix(line, "#") {
+			if err = handle(strings.Fields(line)); err != nil {
+				return
+			}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func parseFloats(fields ...string) (nums []float64, err error) {
+	nums = make([]float64, len(fields))
+	for i, field := range fields {
+		if nums[i], err = strconv.ParseFloat(field, 64); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func parseInts(fields ...string) (nums []int, err error) {
+	nums = make([]int, len(fields))
+	for i, field := range fields {
+		if nums[i], err = strconv.Atoi(field); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func containsIndex(indices []int, index int) bool {
+	for _, elem := range indices {
+		if elem == index {
+			return true
+		}
+	}
+	return false
+}
+
+// loadImageFile : Loads an image of any supported format as a 3 channel image
+func loadImageFile(path string) (*image.CHWImage, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if !supportedExtensions[ext] {
+		return nil, errNotSupportedExt
+	}
+	imageData, _, err := loadImage(path)
+	if err != nil {
+		return nil, err
+	}
+	return To3HWImage(imageData), nil
+}
+
+// fullMask : Returns a mask that doesn't mask any pixel
+func fullMask(height, width int) *image.CHWImage {
+	mask := image.NewImage(height, width, 1)
+	for i := range mask.Data {
+		mask.Data[i] = 1
+	}
+	return mask
+}
@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"context"
+	"math"
+	"pmvs/image"
+	"reflect"
+	"testing"
+)
+
+// The fixtures in testdata share three 8x6 images whose red and green
+// channels ramp with x and y, and whose blue channel tells them apart
+var (
+	identity = [9]float64{1, 0, 0, 0, 1, 0, 0, 0, 1}
+	// rotation of 90 degrees about the y axis
+	rotationY = [9]float64{0, 0, 1, 0, 1, 0, -1, 0, 0}
+)
+
+// rampValue : Returns the value of channel c of the fixture image at (y, x)
+func rampValue(y, x float64, c int, blue float32) float32 {
+	switch c {
+	case 0:
+		return float32(30*x) / 255
+	case 1:
+		return float32(40*y) / 255
+	}
+	return blue
+}
+
+// checkImages : Checks that the images are the fixture images with the
+// given blue values and that their masks mask no pixel
+func checkImages(t *testing.T, images, masks []*image.CHWImage, blues ...float32) {
+	t.Helper()
+	if len(images) != len(blues) || len(masks) != len(blues) {
+		t.Fatalf("got %v images and %v masks, want %v", len(images), len(masks), len(blues))
+	}
+	for i, img := range images {
+		for y := 0; y < img.Height; y++ {
+			for x := 0; x < img.Width; x++ {
+				for c := 0; c < 3; c++ {
+					want := rampValue(float64(y), float64(x), c, blues[i])
+					if got := img.At(y, x, c); math.Abs(float64(got-want)) > 1e-6 {
+						t.Fatalf("image %v: got %v at (%v, %v, %v), want %v", i, got, y, x, c, want)
+					}
+				}
+				if masks[i].At(y, x, 0) != 1 {
+					t.Fatalf("image %v: pixel (%v, %v) is masked", i, y, x)
+				}
+			}
+		}
+	}
+}
+
+// checkProjection : Checks that the projection matrix is K [R | t]
+func checkProjection(t *testing.T, name string, got []float64, k, rot [9]float64,
+	trans [3]float64) {
+	t.Helper()
+	want := composeProjection(k, rot, trans)
+	for i := range want {
+		if len(got) != len(want) || math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Errorf("%v: got projection %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+// checkPoints : Checks the positions, colors and views of the points
+func checkPoints(t *testing.T, points *SparsePoints, positions [][]float64,
+	colors [][]float32, views [][]int) {
+	t.Helper()
+	if !reflect.DeepEqual(points.Positions, positions) {
+		t.Errorf("got positions %v, want %v", points.Positions, positions)
+	}
+	if !reflect.DeepEqual(points.Colors, colors) {
+		t.Errorf("got colors %v, want %v", points.Colors, colors)
+	}
+	if !reflect.DeepEqual(points.Views, views) {
+		t.Errorf("got views %v, want %v", points.Views, views)
+	}
+}
+
+func TestLoadColmap(t *testing.T) {
+	for _, format := range []string{"text", "binary"} {
+		images, masks, mats, points, err := LoadColmap(context.Background(),
+			"testdata/colmap/"+format, "testdata/images", false)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		// the images are sorted by id, a.png has id 1
+		checkImages(t, images, masks, 0, float32(50)/255)
+		if len(mats) != 2 {
+			t.Fatalf("%v: got %v matrices, want 2", format, len(mats))
+		}
+		// COLMAP puts the center of the top left pixel at (0.5, 0.5)
+		checkProjection(t, format+" PINHOLE", mats[0], intrinsicMatrix(100, 110, 3.5, 2.5),
+			identity, [3]float64{0.1, -0.2, 3})
+		checkProjection(t, format+" SIMPLE_RADIAL", mats[1], intrinsicMatrix(90, 90, 4, 3),
+			rotationY, [3]float64{1, 2, 3})
+		// the first point is seen twice in a.png
+		checkPoints(t, points, [][]float64{{0.5, -0.25, 4}, {0, 0, 5}},
+			[][]float32{{1, 0, 0.2}, {0, 1, 0}}, [][]int{{0, 1}, {1}})
+	}
+
+	_, _, _, _, err := LoadColmap(context.Background(), "testdata/images", "testdata/images", false)
+	if err != errMissingModel {
+		t.Errorf("got error %v without a model, want %v", err, errMissingModel)
+	}
+}
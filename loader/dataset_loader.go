@@ -2,6 +2,7 @@ package loader
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	goimage "image"
@@ -33,8 +34,10 @@ var (
 
 // LoadDataset : Loads a dataset consisting of images, projection matrices, and
 // possibly masks. The images must be in "path/images", masks in "path/silhouettes",
-// and matrices in "path/calib". each matrix in 'mats' is in row-major
+// and matrices in "path/calib". each matrix in 'mats' is in row-major.
+// If ctx is cancelled the images loaded so far are returned with its error
 func LoadDataset(
+	ctx context.Context,
 	path string,
 	ext string,
 	mask bool,
@@ -58,6 +61,9 @@ func LoadDataset(
 	silhouettes = make([]*image.CHWImage, 0, 20)
 
 	for i := 0; ; i++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
 		imageData, _, errLoad := loadImage(
 			fmt.Sprintf("%simages/%04d.%s", path, i, ext))
 		if errLoad != nil {
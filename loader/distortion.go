@@ -0,0 +1,69 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"pmvs/lens"
+	"strings"
+)
+
+var (
+	errNotSupportedDistortion = errors.New("Error! Distortion model is not supported")
+)
+
+// LoadDistortions : Loads the distortion of the first count cameras of a
+// dataset laid out as in LoadDataset. The distortion of camera i is read
+// from "path/calib/%04d.dist" which holds the name of the model followed
+// by its parameters, either "BROWN k1 k2 k3 p1 p2" or "FISHEYE k1 k2 k3 k4".
+// Cameras without a distortion file get a nil model
+func LoadDistortions(path string, count int) (models []lens.Model, err error) {
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	models = make([]lens.Model, count)
+	for i := 0; i < count; i++ {
+		distPath := fmt.Sprintf("%scalib/%04d.dist", path, i)
+		if !fileExists(distPath) {
+			continue
+		}
+		if models[i], err = loadDistortion(distPath); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func loadDistortion(path string) (model lens.Model, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	reader := newTokenReader(string(content))
+	name, err := reader.next()
+	if err != nil {
+		return
+	}
+	switch strings.ToUpper(name) {
+	case "BROWN":
+		var params [5]float64
+		if err = reader.floats(params[:]); err != nil {
+			return
+		}
+		model = lens.BrownConrady{
+			K1: params[0], K2: params[1], K3: params[2],
+			P1: params[3], P2: params[4],
+		}
+	case "FISHEYE":
+		var params [4]float64
+		if err = reader.floats(params[:]); err != nil {
+			return
+		}
+		model = lens.Fisheye{
+			K1: params[0], K2: params[1], K3: params[2], K4: params[3],
+		}
+	default:
+		err = errNotSupportedDistortion
+	}
+	return
+}
@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"pmvs/image"
+	"pmvs/lens"
+	"strconv"
+	"strings"
+)
+
+// LoadNVM : Loads the first model of a VisualSfM ".nvm" file along with the
+// images it references, whose paths are relative to the directory of the
+// file. Both the quaternion (NVM_V3) and the rotation matrix (NVM_V3_R9T)
+// variants are supported. If undistort is true the images are resampled to
+// remove the radial distortion, otherwise it is ignored. NVM has no
+// silhouettes, so the returned masks only mask the pixels that
+// undistortion leaves without data. Each matrix in 'mats' is in row-major,
+// and points views index 'images'. If ctx is cancelled the images loaded so
+// far are returned with its error
+func LoadNVM(
+	ctx context.Context,
+	nvmPath string,
+	undistort bool,
+) (images, silhouettes []*image.CHWImage, mats [][]float64,
+	points *SparsePoints, err error) {
+
+	content, err := os.ReadFile(nvmPath)
+	if err != nil {
+		return
+	}
+	text := string(content)
+	headerEnd := strings.IndexByte(text, '\n')
+	if headerEnd < 0 {
+		err = errEmptyFile
+		return
+	}
+	header := strings.Fields(text[:headerEnd])
+	if len(header) == 0 || !strings.HasPrefix(header[0], "NVM_V3") {
+		err = errMalformedFile
+		return
+	}
+	rotationMatrix := strings.HasSuffix(header[0], "R9T")
+	fixedK := false
+	var fixedParams [4]float64
+	if len(header) >= 6 && header[1] == "FixedK" {
+		fixedK = true
+		for i := 0; i < 4; i++ {
+			if fixedParams[i], err = strconv.ParseFloat(header[2+i], 64); err != nil {
+				return
+			}
+		}
+	}
+	reader := newTokenReader(text[headerEnd:])
+	dir := filepath.Dir(nvmPath)
+
+	numCameras, err := reader.int()
+	if err != nil {
+		return
+	}
+	for i := 0; i < numCameras; i++ {
+		var name string
+		if name, err = reader.next(); err != nil {
+			return
+		}
+		var focal float64
+		if focal, err = reader.float(); err != nil {
+			return
+		}
+		var rot [9]float64
+		var trans [3]float64
+		if rotationMatrix {
+			if err = reader.floats(rot[:]); err != nil {
+				return
+			}
+			if err = reader.floats(trans[:]); err != nil {
+				return
+			}
+		} else {
+			var quat [4]float64
+			var center [3]float64
+			if err = reader.floats(quat[:]); err != nil {
+				return
+			}
+			if err = reader.floats(center[:]); err != nil {
+				return
+			}
+			rot = quaternionToRotation(quat)
+			for j := 0; j < 3; j++ {
+				trans[j] = -(rot[j*3]*center[0] + rot[j*3+1]*center[1] + rot[j*3+2]*center[2])
+			}
+		}
+		// radial distortion followed by a zero
+		var distortion [2]float64
+		if err = reader.floats(distortion[:]); err != nil {
+			return
+		}
+
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var imageData *image.CHWImage
+		imageData, err = loadImageFile(filepath.Join(dir, name))
+		if err != nil {
+			return
+		}
+		fx, fy := focal, focal
+		cx := float64(imageData.Width)/2 - 0.5
+		cy := float64(imageData.Height)/2 - 0.5
+		if fixedK {
+			fx, cx, fy, cy = fixedParams[0], fixedParams[1], fixedParams[2], fixedParams[3]
+		}
+		mask := fullMask(imageData.Height, imageData.Width)
+		if undistort && distortion[0] != 0 {
+			imageData, mask = lens.Undistort(imageData, mask,
+				lens.Intrinsics{Fx: fx, Fy: fy, Cx: cx, Cy: cy},
+				// the coefficient is given for distances in pixels
+				lens.MeasurementRadial{K: distortion[0] * fx * fy})
+		}
+		images = append(images, imageData)
+		silhouettes = append(silhouettes, mask)
+		mats = append(mats, composeProjection(intrinsicMatrix(fx, fy, cx, cy), rot, trans))
+	}
+
+	points = new(SparsePoints)
+	numPoints, err := reader.int()
+	if err != nil {
+		// models without points end right after the cameras
+		err = nil
+		return
+	}
+	for i := 0; i < numPoints; i++ {
+		// position and color
+		var params [6]float64
+		if err = reader.floats(params[:]); err != nil {
+			return
+		}
+		var numViews int
+		if numViews, err = reader.int(); err != nil {
+			return
+		}
+		views := make([]int, 0, numViews)
+		for j := 0; j < numViews; j++ {
+			// image index, feature index, x and y
+			var view [4]float64
+			if err = reader.floats(view[:]); err != nil {
+				return
+			}
+			index := int(view[0])
+			if index < 0 || index >= numCameras {
+				err = errMalformedFile
+				return
+			}
+			if !containsIndex(views, index) {
+				views = append(views, index)
+			}
+		}
+		points.Positions = append(points.Positions, []float64{params[0], params[1], params[2]})
+		points.Colors = append(points.Colors, []float32{
+			float32(params[3]) / 255, float32(params[4]) / 255, float32(params[5]) / 255,
+		})
+		points.Views = append(points.Views, views)
+	}
+	return
+}
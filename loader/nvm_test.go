@@ -0,0 +1,65 @@
+package loader
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestLoadNVM(t *testing.T) {
+	images, masks, mats, points, err := LoadNVM(context.Background(),
+		"testdata/nvm/model.nvm", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImages(t, images, masks, 0, float32(50)/255)
+	if len(mats) != 2 {
+		t.Fatalf("got %v matrices, want 2", len(mats))
+	}
+	// NVM_V3 gives camera centers, t = -R C
+	checkProjection(t, "a.png", mats[0], intrinsicMatrix(100, 100, 3.5, 2.5),
+		identity, [3]float64{0, 0, 3})
+	checkProjection(t, "b.png", mats[1], intrinsicMatrix(120, 120, 3.5, 2.5),
+		rotationY, [3]float64{0, 0, 3})
+	checkPoints(t, points, [][]float64{{0, 0, 0}, {0.5, 0.5, 1}},
+		[][]float32{{1, 1, 1}, {0, 0, 1}}, [][]int{{0, 1}, {1}})
+}
+
+func TestLoadNVMUndistort(t *testing.T) {
+	images, masks, _, _, err := LoadNVM(context.Background(), "testdata/nvm/model.nvm", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a.png has no distortion
+	checkImages(t, images[:1], masks[:1], 0)
+
+	// VisualSfM undistorts a measurement m, in pixels from the principal
+	// point, to m * (1 + k |m|^2) with k = -0.002 for b.png
+	img, mask := images[1], masks[1]
+	cx, cy, k := 3.5, 2.5, -0.002
+	var unmasked int
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if mask.At(y, x, 0) == 0 {
+				continue
+			}
+			unmasked++
+			ux, uy := float64(x)-cx, float64(y)-cy
+			mx, my := ux, uy
+			for i := 0; i < 50; i++ {
+				scale := 1 + k*(mx*mx+my*my)
+				mx, my = ux/scale, uy/scale
+			}
+			// the images are linear ramps, bilinear lookups are exact
+			for c := 0; c < 2; c++ {
+				want := rampValue(cy+my, cx+mx, c, 0)
+				if got := img.At(y, x, c); math.Abs(float64(got-want)) > 1e-5 {
+					t.Errorf("got %v at (%v, %v, %v), want %v", got, y, x, c, want)
+				}
+			}
+		}
+	}
+	if unmasked == 0 || unmasked == img.Width*img.Height {
+		t.Errorf("%v pixels out of %v are unmasked", unmasked, img.Width*img.Height)
+	}
+}
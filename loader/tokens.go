@@ -0,0 +1,52 @@
+package loader
+
+import (
+	"strconv"
+	"strings"
+)
+
+// tokenReader : Reads whitespace separated values one at a time
+type tokenReader struct {
+	tokens []string
+	pos    int
+}
+
+func newTokenReader(text string) *tokenReader {
+	reader := new(tokenReader)
+	reader.tokens = strings.Fields(text)
+	return reader
+}
+
+func (reader *tokenReader) next() (string, error) {
+	if reader.pos >= len(reader.tokens) {
+		return "", errMalformedFile
+	}
+	reader.pos++
+	return reader.tokens[reader.pos-1], nil
+}
+
+func (reader *tokenReader) float() (float64, error) {
+	token, err := reader.next()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(token, 64)
+}
+
+func (reader *tokenReader) int() (int, error) {
+	token, err := reader.next()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(token)
+}
+
+// floats : Reads len(dst) values into dst
+func (reader *tokenReader) floats(dst []float64) (err error) {
+	for i := range dst {
+		if dst[i], err = reader.float(); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,237 @@
+package mesh
+
+// Grid : A scalar field sampled on the nodes of a regular grid
+type Grid struct {
+	Size   [3]int
+	Origin [3]float64
+	Step   float64
+	Values []float64
+}
+
+var (
+	// the six tetrahedra of the Kuhn triangulation of a cube, every one of
+	// them contains the main diagonal, so neighbouring cubes split their
+	// shared faces the same way and the extracted surface has no cracks
+	cubeTetrahedra = [6][4]int{
+		{0, 1, 3, 7},
+		{0, 1, 5, 7},
+		{0, 2, 3, 7},
+		{0, 2, 6, 7},
+		{0, 4, 5, 7},
+		{0, 4, 6, 7},
+	}
+)
+
+// NewGrid : Creates new grid of size[0] x size[1] x size[2] nodes whose first
+// node lies at origin and whose nodes are step apart
+func NewGrid(size [3]int, origin [3]float64, step float64) *Grid {
+	grid := new(Grid)
+	grid.Size, grid.Origin, grid.Step = size, origin, step
+	length := size[0] * size[1] * size[2]
+	grid.Values = make([]float64, length, length)
+	return grid
+}
+
+// Index : Returns the index of node (x, y, z) in Values
+func (grid *Grid) Index(x, y, z int) int {
+	return (z*grid.Size[1]+y)*grid.Size[0] + x
+}
+
+// At : Returns the value at node (x, y, z)
+func (grid *Grid) At(x, y, z int) float64 {
+	return grid.Values[grid.Index(x, y, z)]
+}
+
+// Set : Sets the value at node (x, y, z) to be val
+func (grid *Grid) Set(x, y, z int, val float64) {
+	grid.Values[grid.Index(x, y, z)] = val
+}
+
+// Position : Returns the world position of node (x, y, z)
+func (grid *Grid) Position(x, y, z int) [3]float64 {
+	return [3]float64{
+		grid.Origin[0] + float64(x)*grid.Step,
+		grid.Origin[1] + float64(y)*grid.Step,
+		grid.Origin[2] + float64(z)*grid.Step,
+	}
+}
+
+// ExtractIsoSurface : Extracts the surface where the grid equals isoValue.
+// Every cube of the grid is split into tetrahedra (marching tetrahedra),
+// the inside is where the field is greater than isoValue and the faces are
+// oriented so that their normals point outside
+func ExtractIsoSurface(grid *Grid, isoValue float64) *Mesh {
+	extractor := newIsoExtractor(grid, isoValue, func(index int) float64 {
+		return grid.Values[index]
+	})
+	for z := 0; z < grid.Size[2]-1; z++ {
+		for y := 0; y < grid.Size[1]-1; y++ {
+			for x := 0; x < grid.Size[0]-1; x++ {
+				extractor.cube(x, y, z)
+			}
+		}
+	}
+	return extractor.mesh
+}
+
+// ExtractIsoSurfaceCubes : Same as ExtractIsoSurface on a grid whose Values
+// aren't stored, value returns the value at node (x, y, z) and is called
+// once per node it needs. Only the given cubes, named by their first node,
+// are visited, the surface shouldn't cross the others. The mesh is the same
+// as the one of ExtractIsoSurface if the cubes are sorted by z, y then x
+func ExtractIsoSurfaceCubes(grid *Grid, cubes [][3]int, isoValue float64,
+	value func(x, y, z int) float64) *Mesh {
+
+	values := make(map[int]float64)
+	extractor := newIsoExtractor(grid, isoValue, func(index int) float64 {
+		if val, ok := values[index]; ok {
+			return val
+		}
+		x := index % grid.Size[0]
+		y := (index / grid.Size[0]) % grid.Size[1]
+		z := index / (grid.Size[0] * grid.Size[1])
+		val := value(x, y, z)
+		values[index] = val
+		return val
+	})
+	for _, cube := range cubes {
+		extractor.cube(cube[0], cube[1], cube[2])
+	}
+	return extractor.mesh
+}
+
+// isoExtractor : Extracts the surface one cube at a time, sharing the
+// vertices on the edges of the grid between cubes
+type isoExtractor struct {
+	grid         *Grid
+	isoValue     float64
+	value        func(index int) float64
+	mesh         *Mesh
+	edgeVertices map[[2]int]int
+}
+
+func newIsoExtractor(grid *Grid, isoValue float64,
+	value func(index int) float64) *isoExtractor {
+
+	extractor := new(isoExtractor)
+	extractor.grid, extractor.isoValue, extractor.value = grid, isoValue, value
+	extractor.mesh = NewMesh()
+	extractor.edgeVertices = make(map[[2]int]int)
+	return extractor
+}
+
+// edgeVertex : Returns the vertex lying on the edge between nodes a and b
+func (extractor *isoExtractor) edgeVertex(a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	key := [2]int{a, b}
+	if index, ok := extractor.edgeVertices[key]; ok {
+		return index
+	}
+	grid := extractor.grid
+	pa, pb := grid.nodePosition(a), grid.nodePosition(b)
+	va, vb := extractor.value(a), extractor.value(b)
+	t := (extractor.isoValue - va) / (vb - va)
+	index := extractor.mesh.AddVertex(
+		pa[0]+t*(pb[0]-pa[0]),
+		pa[1]+t*(pb[1]-pa[1]),
+		pa[2]+t*(pb[2]-pa[2]),
+	)
+	extractor.edgeVertices[key] = index
+	return index
+}
+
+// cube : Adds the faces of the surface inside the cube whose first node is
+// (x, y, z)
+func (extractor *isoExtractor) cube(x, y, z int) {
+	grid, mesh := extractor.grid, extractor.mesh
+	var corners [8]int
+	var inside, outside [4]int
+	for c := 0; c < 8; c++ {
+		corners[c] = grid.Index(x+c&1, y+(c>>1)&1, z+(c>>2)&1)
+	}
+	for _, tet := range cubeTetrahedra {
+		numInside, numOutside := 0, 0
+		for _, c := range tet {
+			if extractor.value(corners[c]) > extractor.isoValue {
+				inside[numInside] = corners[c]
+				numInside++
+			} else {
+				outside[numOutside] = corners[c]
+				numOutside++
+			}
+		}
+		switch numInside {
+		case 1:
+			grid.addFace(mesh, inside[:1], outside[:3],
+				extractor.edgeVertex(inside[0], outside[0]),
+				extractor.edgeVertex(inside[0], outside[1]),
+				extractor.edgeVertex(inside[0], outside[2]))
+		case 3:
+			grid.addFace(mesh, inside[:3], outside[:1],
+				extractor.edgeVertex(inside[0], outside[0]),
+				extractor.edgeVertex(inside[1], outside[0]),
+				extractor.edgeVertex(inside[2], outside[0]))
+		case 2:
+			v0 := extractor.edgeVertex(inside[0], outside[0])
+			v1 := extractor.edgeVertex(inside[0], outside[1])
+			v2 := extractor.edgeVertex(inside[1], outside[1])
+			v3 := extractor.edgeVertex(inside[1], outside[0])
+			grid.addFace(mesh, inside[:2], outside[:2], v0, v1, v2)
+			grid.addFace(mesh, inside[:2], outside[:2], v0, v2, v3)
+		}
+	}
+}
+
+// nodePosition : Returns the world position of the node at index
+func (grid *Grid) nodePosition(index int) [3]float64 {
+	x := index % grid.Size[0]
+	y := (index / grid.Size[0]) % grid.Size[1]
+	z := index / (grid.Size[0] * grid.Size[1])
+	return grid.Position(x, y, z)
+}
+
+// addFace : Adds the triangle (v0, v1, v2) to the mesh flipping it if needed
+// so that its normal points from the inside nodes to the outside nodes
+func (grid *Grid) addFace(mesh *Mesh, inside, outside []int, v0, v1, v2 int) {
+	if v0 == v1 || v1 == v2 || v0 == v2 {
+		return
+	}
+	var direction [3]float64
+	for _, node := range outside {
+		p := grid.nodePosition(node)
+		for i := 0; i < 3; i++ {
+			direction[i] += p[i] / float64(len(outside))
+		}
+	}
+	for _, node := range inside {
+		p := grid.nodePosition(node)
+		for i := 0; i < 3; i++ {
+			direction[i] -= p[i] / float64(len(inside))
+		}
+	}
+
+	p0, p1, p2 := mesh.Vertices[v0], mesh.Vertices[v1], mesh.Vertices[v2]
+	normal := cross(sub(p1, p0), sub(p2, p0))
+	if dot(normal, direction) < 0 {
+		v1, v2 = v2, v1
+	}
+	mesh.AddFace(v0, v1, v2)
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
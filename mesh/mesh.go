@@ -0,0 +1,137 @@
+package mesh
+
+import (
+	"bufio"
+	"math"
+	"os"
+)
+
+// Mesh : A triangle mesh with optional per-vertex normals and colors
+type Mesh struct {
+	Vertices [][3]float64
+	Faces    [][3]int
+	Normals  [][3]float64
+	Colors   [][3]float32
+}
+
+// NewMesh : Creates new empty mesh
+func NewMesh() *Mesh {
+	mesh := new(Mesh)
+	mesh.Vertices = make([][3]float64, 0, 1024)
+	mesh.Faces = make([][3]int, 0, 2048)
+	return mesh
+}
+
+// AddVertex : Appends a vertex and returns its index
+func (mesh *Mesh) AddVertex(x, y, z float64) int {
+	mesh.Vertices = append(mesh.Vertices, [3]float64{x, y, z})
+	return len(mesh.Vertices) - 1
+}
+
+// AddFace : Appends a triangle given the indices of its vertices
+func (mesh *Mesh) AddFace(v0, v1, v2 int) {
+	mesh.Faces = append(mesh.Faces, [3]int{v0, v1, v2})
+}
+
+// HasNormals : Returns whether every vertex has a normal
+func (mesh *Mesh) HasNormals() bool {
+	return len(mesh.Normals) == len(mesh.Vertices) && len(mesh.Vertices) > 0
+}
+
+// HasColors : Returns whether every vertex has a color
+func (mesh *Mesh) HasColors() bool {
+	return len(mesh.Colors) == len(mesh.Vertices) && len(mesh.Vertices) > 0
+}
+
+// FaceNormal : Returns the unit normal of face i, the zero vector if the
+// face is degenerate
+func (mesh *Mesh) FaceNormal(i int) [3]float64 {
+	face := mesh.Faces[i]
+	p0, p1, p2 := mesh.Vertices[face[0]], mesh.Vertices[face[1]], mesh.Vertices[face[2]]
+	return normalized(cross(sub(p1, p0), sub(p2, p0)))
+}
+
+// ComputeNormals : Sets the normal of every vertex to the area weighted
+// average of the normals of the faces around it
+func (mesh *Mesh) ComputeNormals() {
+	mesh.Normals = make([][3]float64, len(mesh.Vertices))
+	for _, face := range mesh.Faces {
+		p0, p1, p2 := mesh.Vertices[face[0]], mesh.Vertices[face[1]], mesh.Vertices[face[2]]
+		// the length of the cross product is twice the area of the face
+		normal := cross(sub(p1, p0), sub(p2, p0))
+		for _, v := range face {
+			for c := 0; c < 3; c++ {
+				mesh.Normals[v][c] += normal[c]
+			}
+		}
+	}
+	for i := range mesh.Normals {
+		mesh.Normals[i] = normalized(mesh.Normals[i])
+	}
+}
+
+// KeepVertices : Removes every vertex for which keep returns false along
+// with the faces that use it, and reindexes the remaining faces
+func (mesh *Mesh) KeepVertices(keep func(i int) bool) {
+	hasNormals, hasColors := mesh.HasNormals(), mesh.HasColors()
+	newIndex := make([]int, len(mesh.Vertices))
+	numKept := 0
+	for i := range mesh.Vertices {
+		if !keep(i) {
+			newIndex[i] = -1
+			continue
+		}
+		newIndex[i] = numKept
+		mesh.Vertices[numKept] = mesh.Vertices[i]
+		if hasNormals {
+			mesh.Normals[numKept] = mesh.Normals[i]
+		}
+		if hasColors {
+			mesh.Colors[numKept] = mesh.Colors[i]
+		}
+		numKept++
+	}
+	mesh.Vertices = mesh.Vertices[:numKept]
+	if hasNormals {
+		mesh.Normals = mesh.Normals[:numKept]
+	}
+	if hasColors {
+		mesh.Colors = mesh.Colors[:numKept]
+	}
+
+	faces := mesh.Faces[:0]
+	for _, face := range mesh.Faces {
+		v0, v1, v2 := newIndex[face[0]], newIndex[face[1]], newIndex[face[2]]
+		if v0 < 0 || v1 < 0 || v2 < 0 {
+			continue
+		}
+		faces = append(faces, [3]int{v0, v1, v2})
+	}
+	mesh.Faces = faces
+}
+
+// writeFile : Creates the file at path and fills it with write through a
+// buffered writer. The error of write, of flushing or of closing the file is
+// returned, the first one that happened
+func writeFile(path string, write func(writer *bufio.Writer) error) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(file)
+	if err = write(writer); err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+func normalized(vec [3]float64) [3]float64 {
+	norm := math.Sqrt(dot(vec, vec))
+	if norm == 0 {
+		return vec
+	}
+	return [3]float64{vec[0] / norm, vec[1] / norm, vec[2] / norm}
+}
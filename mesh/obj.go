@@ -0,0 +1,61 @@
+package mesh
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// WriteOBJ : Writes the mesh in Wavefront OBJ format along with a material
+// library next to it having the same name and the "mtl" extension.
+// Vertex colors, if present, are written after the vertex coordinates
+func WriteOBJ(path string, mesh *Mesh) (err error) {
+	mtlPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".mtl"
+	if err = writeFile(mtlPath, writeMTL); err != nil {
+		return
+	}
+	return writeFile(path, func(writer *bufio.Writer) error {
+		writeOBJ(writer, mesh, filepath.Base(mtlPath))
+		return nil
+	})
+}
+
+func writeOBJ(writer *bufio.Writer, mesh *Mesh, mtlName string) {
+	fmt.Fprintf(writer, "mtllib %s\n", mtlName)
+	hasColors, hasNormals := mesh.HasColors(), mesh.HasNormals()
+	for i, v := range mesh.Vertices {
+		if hasColors {
+			c := mesh.Colors[i]
+			fmt.Fprintf(writer, "v %g %g %g %g %g %g\n", v[0], v[1], v[2], c[0], c[1], c[2])
+		} else {
+			fmt.Fprintf(writer, "v %g %g %g\n", v[0], v[1], v[2])
+		}
+	}
+	if hasNormals {
+		for _, n := range mesh.Normals {
+			fmt.Fprintf(writer, "vn %g %g %g\n", n[0], n[1], n[2])
+		}
+	}
+
+	fmt.Fprintln(writer, "usemtl default")
+	for _, f := range mesh.Faces {
+		// obj indices start from 1
+		if hasNormals {
+			fmt.Fprintf(writer, "f %d//%d %d//%d %d//%d\n",
+				f[0]+1, f[0]+1, f[1]+1, f[1]+1, f[2]+1, f[2]+1)
+		} else {
+			fmt.Fprintf(writer, "f %d %d %d\n", f[0]+1, f[1]+1, f[2]+1)
+		}
+	}
+}
+
+func writeMTL(writer *bufio.Writer) error {
+	fmt.Fprintln(writer, "newmtl default")
+	fmt.Fprintln(writer, "Ka 0.2 0.2 0.2")
+	fmt.Fprintln(writer, "Kd 0.8 0.8 0.8")
+	fmt.Fprintln(writer, "Ks 0 0 0")
+	fmt.Fprintln(writer, "d 1")
+	fmt.Fprintln(writer, "illum 1")
+	return nil
+}
@@ -0,0 +1,90 @@
+package mesh
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readOBJ : Reads the OBJ files written by WriteOBJ, checking that they use
+// their material library
+func readOBJ(t *testing.T, path string) *Mesh {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	mesh := NewMesh()
+	parseFloats := func(fields []string) []float64 {
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			if values[i], err = strconv.ParseFloat(field, 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return values
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch fields[0] {
+		case "mtllib":
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), fields[1])); err != nil {
+				t.Error(err)
+			}
+		case "v":
+			values := parseFloats(fields[1:])
+			mesh.AddVertex(values[0], values[1], values[2])
+			if len(values) == 6 {
+				mesh.Colors = append(mesh.Colors,
+					[3]float32{float32(values[3]), float32(values[4]), float32(values[5])})
+			}
+		case "vn":
+			values := parseFloats(fields[1:])
+			mesh.Normals = append(mesh.Normals, [3]float64{values[0], values[1], values[2]})
+		case "f":
+			var face [3]int
+			for i, field := range fields[1:] {
+				// vertex//normal, the normal of a vertex has its index
+				indices := strings.Split(field, "//")
+				index, err := strconv.Atoi(indices[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(indices) == 2 && indices[1] != indices[0] {
+					t.Errorf("face %v uses normal %v for vertex %v", field, indices[1], indices[0])
+				}
+				face[i] = index - 1
+			}
+			mesh.Faces = append(mesh.Faces, face)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return mesh
+}
+
+func TestWriteOBJ(t *testing.T) {
+	dir := t.TempDir()
+	bare := newTestMesh()
+	bare.Normals, bare.Colors = nil, nil
+	for _, mesh := range []*Mesh{newTestMesh(), bare} {
+		path := filepath.Join(dir, "mesh.obj")
+		if err := WriteOBJ(path, mesh); err != nil {
+			t.Fatal(err)
+		}
+		if got := readOBJ(t, path); !reflect.DeepEqual(got, mesh) {
+			t.Errorf("got %+v, want %+v", got, mesh)
+		}
+	}
+	if err := WriteOBJ(filepath.Join(dir, "missing", "mesh.obj"), bare); err == nil {
+		t.Error("wrote to a missing directory")
+	}
+}
@@ -0,0 +1,115 @@
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// WritePLY : Writes the mesh in PLY format, either ASCII or binary little
+// endian. Normals and colors are written if present
+func WritePLY(path string, mesh *Mesh, binaryFormat bool) error {
+	return writeFile(path, func(writer *bufio.Writer) error {
+		return writePLY(writer, mesh, binaryFormat)
+	})
+}
+
+func writePLY(writer *bufio.Writer, mesh *Mesh, binaryFormat bool) error {
+	hasColors, hasNormals := mesh.HasColors(), mesh.HasNormals()
+	format := "ascii"
+	if binaryFormat {
+		format = "binary_little_endian"
+	}
+	fmt.Fprintln(writer, "ply")
+	fmt.Fprintf(writer, "format %s 1.0\n", format)
+	fmt.Fprintf(writer, "element vertex %d\n", len(mesh.Vertices))
+	fmt.Fprintln(writer, "property float x")
+	fmt.Fprintln(writer, "property float y")
+	fmt.Fprintln(writer, "property float z")
+	if hasNormals {
+		fmt.Fprintln(writer, "property float nx")
+		fmt.Fprintln(writer, "property float ny")
+		fmt.Fprintln(writer, "property float nz")
+	}
+	if hasColors {
+		fmt.Fprintln(writer, "property uchar red")
+		fmt.Fprintln(writer, "property uchar green")
+		fmt.Fprintln(writer, "property uchar blue")
+	}
+	fmt.Fprintf(writer, "element face %d\n", len(mesh.Faces))
+	fmt.Fprintln(writer, "property list uchar int vertex_indices")
+	fmt.Fprintln(writer, "end_header")
+
+	if binaryFormat {
+		return writePLYBinary(writer, mesh, hasNormals, hasColors)
+	}
+	writePLYASCII(writer, mesh, hasNormals, hasColors)
+	return nil
+}
+
+func writePLYASCII(writer *bufio.Writer, mesh *Mesh, hasNormals, hasColors bool) {
+	for i, v := range mesh.Vertices {
+		fmt.Fprintf(writer, "%g %g %g", float32(v[0]), float32(v[1]), float32(v[2]))
+		if hasNormals {
+			n := mesh.Normals[i]
+			fmt.Fprintf(writer, " %g %g %g", float32(n[0]), float32(n[1]), float32(n[2]))
+		}
+		if hasColors {
+			c := mesh.Colors[i]
+			fmt.Fprintf(writer, " %d %d %d", colorByte(c[0]), colorByte(c[1]), colorByte(c[2]))
+		}
+		fmt.Fprintln(writer)
+	}
+	for _, f := range mesh.Faces {
+		fmt.Fprintf(writer, "3 %d %d %d\n", f[0], f[1], f[2])
+	}
+}
+
+func writePLYBinary(writer *bufio.Writer, mesh *Mesh, hasNormals, hasColors bool) (err error) {
+	buf := make([]byte, 0, 27)
+	for i, v := range mesh.Vertices {
+		buf = buf[:0]
+		buf = appendFloat32(buf, v[0], v[1], v[2])
+		if hasNormals {
+			n := mesh.Normals[i]
+			buf = appendFloat32(buf, n[0], n[1], n[2])
+		}
+		if hasColors {
+			c := mesh.Colors[i]
+			buf = append(buf, colorByte(c[0]), colorByte(c[1]), colorByte(c[2]))
+		}
+		if _, err = writer.Write(buf); err != nil {
+			return
+		}
+	}
+	for _, f := range mesh.Faces {
+		buf = buf[:0]
+		buf = append(buf, 3)
+		for _, v := range f {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(v)))
+		}
+		if _, err = writer.Write(buf); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func appendFloat32(buf []byte, vals ...float64) []byte {
+	for _, val := range vals {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(val)))
+	}
+	return buf
+}
+
+// colorByte : Converts a color channel in [0, 1] to a byte
+func colorByte(val float32) byte {
+	if val <= 0 {
+		return 0
+	}
+	if val >= 1 {
+		return 255
+	}
+	return byte(val*255 + 0.5)
+}
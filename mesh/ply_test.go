@@ -0,0 +1,165 @@
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestMesh : Returns a tetrahedron whose coordinates and colors survive
+// their conversion to float32 and to bytes
+func newTestMesh() *Mesh {
+	mesh := NewMesh()
+	mesh.AddVertex(0, 0, 0)
+	mesh.AddVertex(1.5, 0, 0)
+	mesh.AddVertex(0, -2.25, 0)
+	mesh.AddVertex(0, 0, 0.125)
+	mesh.AddFace(0, 2, 1)
+	mesh.AddFace(0, 1, 3)
+	mesh.AddFace(0, 3, 2)
+	mesh.AddFace(1, 2, 3)
+	mesh.Normals = [][3]float64{{-1, 0, 0}, {1, 0, 0}, {0, -1, 0}, {0, 0, 1}}
+	mesh.Colors = [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 51.0 / 255, 1}, {1, 1, 1}}
+	return mesh
+}
+
+// readPLY : Reads the PLY files written by WritePLY
+func readPLY(t *testing.T, path string) *Mesh {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var format string
+	var properties []string
+	var numVertices, numFaces int
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("the header ends early: %v", err)
+		}
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "format":
+			format = fields[1]
+		case fields[0] == "element" && fields[1] == "vertex":
+			numVertices, _ = strconv.Atoi(fields[2])
+		case fields[0] == "element" && fields[1] == "face":
+			numFaces, _ = strconv.Atoi(fields[2])
+		case fields[0] == "property" && fields[1] != "list":
+			properties = append(properties, fields[2])
+		}
+		if fields[0] == "end_header" {
+			break
+		}
+	}
+
+	// the values of every vertex and face, in the order of the file
+	var vertexValues [][]float64
+	var faces [][3]int
+	if format == "ascii" {
+		for i := 0; i < numVertices+numFaces; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []float64
+			for _, field := range strings.Fields(line) {
+				val, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values = append(values, val)
+			}
+			if i < numVertices {
+				vertexValues = append(vertexValues, values)
+			} else if len(values) != 4 || values[0] != 3 {
+				t.Fatalf("face %v", values)
+			} else {
+				faces = append(faces, [3]int{int(values[1]), int(values[2]), int(values[3])})
+			}
+		}
+	} else {
+		for i := 0; i < numVertices; i++ {
+			var values []float64
+			for _, property := range properties {
+				if property == "red" || property == "green" || property == "blue" {
+					var val uint8
+					binary.Read(reader, binary.LittleEndian, &val)
+					values = append(values, float64(val))
+				} else {
+					var val float32
+					binary.Read(reader, binary.LittleEndian, &val)
+					values = append(values, float64(val))
+				}
+			}
+			vertexValues = append(vertexValues, values)
+		}
+		for i := 0; i < numFaces; i++ {
+			var count uint8
+			var face [3]int32
+			binary.Read(reader, binary.LittleEndian, &count)
+			if err := binary.Read(reader, binary.LittleEndian, &face); err != nil || count != 3 {
+				t.Fatalf("face %v has %v vertices: %v", i, count, err)
+			}
+			faces = append(faces, [3]int{int(face[0]), int(face[1]), int(face[2])})
+		}
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("the file goes on after the faces")
+	}
+
+	column := make(map[string]int)
+	for j, property := range properties {
+		column[property] = j
+	}
+	_, hasNormals := column["nx"]
+	_, hasColors := column["red"]
+	mesh := NewMesh()
+	mesh.Faces = faces
+	for _, values := range vertexValues {
+		var vertex, normal [3]float64
+		var color [3]float32
+		for c := 0; c < 3; c++ {
+			vertex[c] = values[column[[]string{"x", "y", "z"}[c]]]
+			normal[c] = values[column[[]string{"nx", "ny", "nz"}[c]]]
+			color[c] = float32(values[column[[]string{"red", "green", "blue"}[c]]] / 255)
+		}
+		mesh.Vertices = append(mesh.Vertices, vertex)
+		if hasNormals {
+			mesh.Normals = append(mesh.Normals, normal)
+		}
+		if hasColors {
+			mesh.Colors = append(mesh.Colors, color)
+		}
+	}
+	return mesh
+}
+
+func TestWritePLY(t *testing.T) {
+	dir := t.TempDir()
+	bare := newTestMesh()
+	bare.Normals, bare.Colors = nil, nil
+	for _, mesh := range []*Mesh{newTestMesh(), bare} {
+		for _, binaryFormat := range []bool{false, true} {
+			path := filepath.Join(dir, "mesh.ply")
+			if err := WritePLY(path, mesh, binaryFormat); err != nil {
+				t.Fatal(err)
+			}
+			if got := readPLY(t, path); !reflect.DeepEqual(got, mesh) {
+				t.Errorf("binary %v: got %+v, want %+v", binaryFormat, got, mesh)
+			}
+		}
+	}
+	if err := WritePLY(filepath.Join(dir, "missing", "mesh.ply"), bare, false); err == nil {
+		t.Error("wrote to a missing directory")
+	}
+}
@@ -0,0 +1,42 @@
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+)
+
+// WriteSTL : Writes the mesh in binary STL format. STL stores independent
+// triangles with their normals, so colors and vertex sharing are lost
+func WriteSTL(path string, mesh *Mesh) error {
+	return writeFile(path, func(writer *bufio.Writer) error {
+		return writeSTL(writer, mesh)
+	})
+}
+
+func writeSTL(writer *bufio.Writer, mesh *Mesh) (err error) {
+	header := make([]byte, 80)
+	copy(header, "binary STL written by pmvs")
+	if _, err = writer.Write(header); err != nil {
+		return
+	}
+	if err = binary.Write(writer, binary.LittleEndian, uint32(len(mesh.Faces))); err != nil {
+		return
+	}
+
+	buf := make([]byte, 0, 50)
+	for i, f := range mesh.Faces {
+		buf = buf[:0]
+		n := mesh.FaceNormal(i)
+		buf = appendFloat32(buf, n[0], n[1], n[2])
+		for _, v := range f {
+			p := mesh.Vertices[v]
+			buf = appendFloat32(buf, p[0], p[1], p[2])
+		}
+		// attribute byte count
+		buf = append(buf, 0, 0)
+		if _, err = writer.Write(buf); err != nil {
+			return
+		}
+	}
+	return
+}
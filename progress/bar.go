@@ -0,0 +1,72 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"pmvs/core"
+	"strings"
+	"time"
+)
+
+const (
+	barWidth = 30
+	// minimum time between two draws of the bar
+	redrawInterval = 100 * time.Millisecond
+)
+
+// Bar : Draws the progress of the current phase of the reconstruction as a
+// bar on a terminal, it can be combined with a logger through
+// core.MultiObserver
+type Bar struct {
+	core.BaseObserver
+	writer   io.Writer
+	phase    string
+	start    time.Time
+	lastDraw time.Time
+	patches  int
+}
+
+// NewBar : Creates new progress bar drawn on writer
+func NewBar(writer io.Writer) *Bar {
+	bar := new(Bar)
+	bar.writer = writer
+	return bar
+}
+
+// PhaseStarted : Starts a new bar for the phase
+func (bar *Bar) PhaseStarted(phase string, total int) {
+	bar.phase, bar.start, bar.patches = phase, time.Now(), 0
+	bar.draw(0, total)
+}
+
+// Progress : Redraws the bar, at most every redrawInterval
+func (bar *Bar) Progress(phase string, done, total int) {
+	if done < total && time.Since(bar.lastDraw) < redrawInterval {
+		return
+	}
+	bar.phase = phase
+	bar.draw(done, total)
+}
+
+// PatchCreated : Counts the patch
+func (bar *Bar) PatchCreated(patch *core.Patch) {
+	bar.patches++
+}
+
+// PhaseEnded : Ends the line of the bar
+func (bar *Bar) PhaseEnded(phase string, patches int, elapsed time.Duration) {
+	fmt.Fprintf(bar.writer, "\r\033[K%s: %d patches in %s\n", phase, patches,
+		elapsed.Round(time.Second))
+}
+
+func (bar *Bar) draw(done, total int) {
+	bar.lastDraw = time.Now()
+	fraction := 1.0
+	if total > 0 {
+		fraction = float64(done) / float64(total)
+	}
+	filled := int(fraction * barWidth)
+	fmt.Fprintf(bar.writer, "\r\033[K%s [%s%s] %d/%d %3.0f%% %d patches %s",
+		bar.phase, strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		done, total, fraction*100, bar.patches, time.Since(bar.start).Round(time.Second))
+}
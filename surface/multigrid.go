@@ -0,0 +1,160 @@
+package surface
+
+const (
+	smoothingSteps       = 2
+	coarsestSolveSteps   = 50
+	coarsestGridCellsNum = 2
+)
+
+// level : One level of the multigrid hierarchy. The unknowns live on the
+// (n+1)^3 nodes of a grid of n^3 cells of side h, boundary nodes are fixed
+// at zero (the indicator function vanishes outside the reconstruction cube)
+type level struct {
+	n      int
+	h      float64
+	alpha  float64
+	x      []float64
+	b      []float64
+	w      []float64
+	r      []float64
+	coarse *level
+}
+
+func newLevel(n int, h, alpha float64) *level {
+	lvl := new(level)
+	lvl.n, lvl.h, lvl.alpha = n, h, alpha
+	size := (n + 1) * (n + 1) * (n + 1)
+	lvl.x = make([]float64, size, size)
+	lvl.b = make([]float64, size, size)
+	lvl.w = make([]float64, size, size)
+	lvl.r = make([]float64, size, size)
+	return lvl
+}
+
+// newHierarchy : Creates the levels down to the coarsest grid, the screening
+// weights w of the finest level are restricted to the coarser levels
+func newHierarchy(finest *level) {
+	lvl := finest
+	for lvl.n > coarsestGridCellsNum {
+		lvl.coarse = newLevel(lvl.n/2, lvl.h*2, lvl.alpha)
+		restrict(lvl, lvl.w, lvl.coarse.w)
+		lvl = lvl.coarse
+	}
+}
+
+func (lvl *level) index(x, y, z int) int {
+	return (z*(lvl.n+1)+y)*(lvl.n+1) + x
+}
+
+// smooth : Red-black Gauss-Seidel iterations on (-laplacian + alpha*w) x = b
+func (lvl *level) smooth(iterations int) {
+	stride := lvl.n + 1
+	invH2 := 1 / (lvl.h * lvl.h)
+	for it := 0; it < iterations; it++ {
+		for color := 0; color < 2; color++ {
+			for z := 1; z < lvl.n; z++ {
+				for y := 1; y < lvl.n; y++ {
+					start := 1 + (z+y+1+color)%2
+					for x := start; x < lvl.n; x += 2 {
+						i := lvl.index(x, y, z)
+						neighbours := lvl.x[i-1] + lvl.x[i+1] +
+							lvl.x[i-stride] + lvl.x[i+stride] +
+							lvl.x[i-stride*stride] + lvl.x[i+stride*stride]
+						lvl.x[i] = (lvl.b[i] + neighbours*invH2) /
+							(6*invH2 + lvl.alpha*lvl.w[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+// residual : Computes r = b - (-laplacian + alpha*w) x
+func (lvl *level) residual() {
+	stride := lvl.n + 1
+	invH2 := 1 / (lvl.h * lvl.h)
+	for z := 1; z < lvl.n; z++ {
+		for y := 1; y < lvl.n; y++ {
+			for x := 1; x < lvl.n; x++ {
+				i := lvl.index(x, y, z)
+				neighbours := lvl.x[i-1] + lvl.x[i+1] +
+					lvl.x[i-stride] + lvl.x[i+stride] +
+					lvl.x[i-stride*stride] + lvl.x[i+stride*stride]
+				ax := (6*lvl.x[i]-neighbours)*invH2 + lvl.alpha*lvl.w[i]*lvl.x[i]
+				lvl.r[i] = lvl.b[i] - ax
+			}
+		}
+	}
+}
+
+// restrict : Transfers a fine field to the coarser level with full weighting
+func restrict(fine *level, fineField, coarseField []float64) {
+	coarse := fine.coarse
+	for z := 1; z < coarse.n; z++ {
+		for y := 1; y < coarse.n; y++ {
+			for x := 1; x < coarse.n; x++ {
+				var sum float64
+				for dz := -1; dz <= 1; dz++ {
+					for dy := -1; dy <= 1; dy++ {
+						for dx := -1; dx <= 1; dx++ {
+							weight := 1.0
+							if dx != 0 {
+								weight *= 0.5
+							}
+							if dy != 0 {
+								weight *= 0.5
+							}
+							if dz != 0 {
+								weight *= 0.5
+							}
+							sum += weight * fineField[fine.index(2*x+dx, 2*y+dy, 2*z+dz)]
+						}
+					}
+				}
+				coarseField[coarse.index(x, y, z)] = sum / 8
+			}
+		}
+	}
+}
+
+// prolongAdd : Interpolates the coarse solution trilinearly and adds it to
+// the fine solution
+func prolongAdd(fine *level) {
+	coarse := fine.coarse
+	for z := 1; z < fine.n; z++ {
+		z0, z1 := z/2, (z+1)/2
+		for y := 1; y < fine.n; y++ {
+			y0, y1 := y/2, (y+1)/2
+			for x := 1; x < fine.n; x++ {
+				x0, x1 := x/2, (x+1)/2
+				val := coarse.x[coarse.index(x0, y0, z0)] +
+					coarse.x[coarse.index(x1, y0, z0)] +
+					coarse.x[coarse.index(x0, y1, z0)] +
+					coarse.x[coarse.index(x1, y1, z0)] +
+					coarse.x[coarse.index(x0, y0, z1)] +
+					coarse.x[coarse.index(x1, y0, z1)] +
+					coarse.x[coarse.index(x0, y1, z1)] +
+					coarse.x[coarse.index(x1, y1, z1)]
+				fine.x[fine.index(x, y, z)] += val / 8
+			}
+		}
+	}
+}
+
+// vCycle : Runs one multigrid V-cycle starting at lvl
+func (lvl *level) vCycle() {
+	if lvl.coarse == nil {
+		lvl.smooth(coarsestSolveSteps)
+		return
+	}
+	lvl.smooth(smoothingSteps)
+	lvl.residual()
+	coarse := lvl.coarse
+	restrict(lvl, lvl.r, coarse.b)
+	for i := range coarse.x {
+		coarse.x[i] = 0
+	}
+	coarse.vCycle()
+	prolongAdd(lvl)
+	lvl.smooth(smoothingSteps)
+}
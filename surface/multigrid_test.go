@@ -0,0 +1,57 @@
+package surface
+
+import (
+	"math"
+	"testing"
+)
+
+// residualNorm : Returns the euclidean norm of the residual of lvl
+func residualNorm(lvl *level) float64 {
+	lvl.residual()
+	var sum float64
+	for _, r := range lvl.r {
+		sum += r * r
+	}
+	return math.Sqrt(sum)
+}
+
+func TestVCycle(t *testing.T) {
+	for _, alpha := range []float64{0, 4} {
+		// -laplacian u + alpha*u = b for u = sin(pi x) sin(pi y) sin(pi z)
+		n := 32
+		finest := newLevel(n, 1/float64(n), alpha)
+		exact := make([]float64, len(finest.x))
+		for z := 0; z <= n; z++ {
+			for y := 0; y <= n; y++ {
+				for x := 0; x <= n; x++ {
+					i := finest.index(x, y, z)
+					finest.w[i] = 1
+					exact[i] = math.Sin(math.Pi*float64(x)/float64(n)) *
+						math.Sin(math.Pi*float64(y)/float64(n)) *
+						math.Sin(math.Pi*float64(z)/float64(n))
+					finest.b[i] = (3*math.Pi*math.Pi + alpha) * exact[i]
+				}
+			}
+		}
+		newHierarchy(finest)
+
+		previous := residualNorm(finest)
+		for cycle := 0; cycle < 6; cycle++ {
+			finest.vCycle()
+			norm := residualNorm(finest)
+			if norm > previous/5 {
+				t.Fatalf("alpha %v: cycle %v reduced the residual from %v to %v only",
+					alpha, cycle, previous, norm)
+			}
+			previous = norm
+		}
+
+		var maxError float64
+		for i := range exact {
+			maxError = math.Max(maxError, math.Abs(finest.x[i]-exact[i]))
+		}
+		if maxError > 0.01 {
+			t.Errorf("alpha %v: the solution is %v away from the exact one", alpha, maxError)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package surface
+
+// octreeNode : A node of the sample octree, it covers a cube of the unit
+// cube and counts the samples that fall inside it
+type octreeNode struct {
+	children [8]*octreeNode
+	count    int
+}
+
+// octree : Counts samples at every depth to estimate the local density,
+// which sets the splat radius and the area of each sample. It isn't the
+// domain of the solve, the multigrid levels are uniform grids
+type octree struct {
+	root     *octreeNode
+	maxDepth int
+}
+
+func newOctree(maxDepth int) *octree {
+	tree := new(octree)
+	tree.root = new(octreeNode)
+	tree.maxDepth = maxDepth
+	return tree
+}
+
+// childIndex : Returns the child of a node at depth containing the point p
+// that lies in the unit cube
+func childIndex(p [3]float64, depth int) int {
+	index := 0
+	for i := 0; i < 3; i++ {
+		cells := float64(int(1) << uint(depth+1))
+		if int(p[i]*cells)&1 == 1 {
+			index |= 1 << uint(i)
+		}
+	}
+	return index
+}
+
+// insert : Inserts a point of the unit cube down to the maximum depth
+func (tree *octree) insert(p [3]float64) {
+	node := tree.root
+	node.count++
+	for depth := 0; depth < tree.maxDepth; depth++ {
+		child := childIndex(p, depth)
+		if node.children[child] == nil {
+			node.children[child] = new(octreeNode)
+		}
+		node = node.children[child]
+		node.count++
+	}
+}
+
+// adaptiveDepth : Returns the deepest depth at which the node containing p
+// still has at least minSamples samples, along with that number of samples
+func (tree *octree) adaptiveDepth(p [3]float64, minSamples int) (depth, count int) {
+	node := tree.root
+	count = node.count
+	for depth = 0; depth < tree.maxDepth; depth++ {
+		child := node.children[childIndex(p, depth)]
+		if child == nil || child.count < minSamples {
+			break
+		}
+		node = child
+		count = node.count
+	}
+	return
+}
@@ -0,0 +1,301 @@
+package surface
+
+import (
+	"math"
+	"pmvs/core"
+	"pmvs/mesh"
+	"sort"
+)
+
+const (
+	maxSplatRadius = 4
+)
+
+// PoissonOptions : Parameters of the screened poisson reconstruction
+type PoissonOptions struct {
+	// Depth : The indicator function is solved for on a uniform grid of
+	// 2^Depth cells per side, (2^Depth+1)^3 nodes. It is also the maximum
+	// depth of the octree estimating the density of the samples
+	Depth int
+	// SamplesPerNode : Minimum number of samples an octree node must contain
+	// for samples inside it to be splatted with a kernel as wide as the
+	// node. Larger values smooth noisy patches more
+	SamplesPerNode int
+	// ScreenWeight : Weight of the term pulling the surface through the
+	// patch centers, zero gives the unscreened reconstruction
+	ScreenWeight float64
+	// Scale : Ratio between the side of the reconstruction cube and the
+	// side of the bounding cube of the patches
+	Scale float64
+	// Cycles : Number of multigrid V-cycles
+	Cycles int
+	// Trim : Fraction of vertices, with the lowest sample density, that are
+	// removed from the output mesh
+	Trim float64
+}
+
+// sample : An oriented point in the unit cube
+type sample struct {
+	pos    [3]float64
+	normal [3]float64
+	depth  int
+	area   float64
+}
+
+// NewPoissonOptions : Creates poisson options with the default values
+func NewPoissonOptions() *PoissonOptions {
+	options := new(PoissonOptions)
+	options.Depth = 7
+	options.SamplesPerNode = 2
+	options.ScreenWeight = 4
+	options.Scale = 1.1
+	options.Cycles = 6
+	options.Trim = 0
+	return options
+}
+
+// PoissonReconstruct : Reconstructs a watertight triangle mesh from the
+// patches using their centers and normals. The normals must point outside
+// the surface, which is the case for patches facing their cameras. The solve
+// isn't adaptive, memory and time grow with the (2^Depth+1)^3 nodes of the
+// grid whatever the number of patches
+func PoissonReconstruct(patches []*core.Patch, options *PoissonOptions) *mesh.Mesh {
+	if options.Depth < 2 {
+		panic("Poisson depth should be at least 2")
+	}
+	samples, origin, side := collectSamples(patches, options.Scale)
+	if len(samples) == 0 {
+		return mesh.NewMesh()
+	}
+
+	n := 1 << uint(options.Depth)
+	tree := newOctree(options.Depth)
+	for _, s := range samples {
+		tree.insert(s.pos)
+	}
+	for i := range samples {
+		depth, count := tree.adaptiveDepth(samples[i].pos, options.SamplesPerNode)
+		nodeSide := float64(n) / float64(int(1)<<uint(depth))
+		samples[i].depth = depth
+		samples[i].area = nodeSide * nodeSide / float64(count)
+	}
+
+	finest := newLevel(n, 1, options.ScreenWeight)
+	density := make([]float64, len(finest.x))
+	field := [3][]float64{
+		make([]float64, len(finest.x)),
+		make([]float64, len(finest.x)),
+		make([]float64, len(finest.x)),
+	}
+	for _, s := range samples {
+		radius := 1 << uint(options.Depth-s.depth)
+		if radius > maxSplatRadius {
+			radius = maxSplatRadius
+		}
+		splat(finest, s.pos, radius, func(i int, weight float64) {
+			density[i] += weight
+			finest.w[i] += s.area * weight
+			for c := 0; c < 3; c++ {
+				field[c][i] += s.area * weight * s.normal[c]
+			}
+		})
+	}
+
+	// right hand side: divergence of the vector field plus the screening
+	// term pulling the indicator function to 0.5 at the samples
+	stride := n + 1
+	for z := 1; z < n; z++ {
+		for y := 1; y < n; y++ {
+			for x := 1; x < n; x++ {
+				i := finest.index(x, y, z)
+				div := (field[0][i+1]-field[0][i-1])/2 +
+					(field[1][i+stride]-field[1][i-stride])/2 +
+					(field[2][i+stride*stride]-field[2][i-stride*stride])/2
+				finest.b[i] = div + 0.5*options.ScreenWeight*finest.w[i]
+			}
+		}
+	}
+
+	newHierarchy(finest)
+	for cycle := 0; cycle < options.Cycles; cycle++ {
+		finest.vCycle()
+	}
+
+	// the iso value is the average of the indicator function at the samples
+	var isoValue float64
+	for _, s := range samples {
+		isoValue += trilinear(finest, finest.x, s.pos)
+	}
+	isoValue /= float64(len(samples))
+
+	step := side / float64(n)
+	grid := mesh.NewGrid([3]int{n + 1, n + 1, n + 1}, origin, step)
+	copy(grid.Values, finest.x)
+	result := mesh.ExtractIsoSurface(grid, isoValue)
+
+	if options.Trim > 0 {
+		trimByDensity(result, finest, density, origin, side, options.Trim)
+	}
+	return result
+}
+
+// collectSamples : Maps patch centers into the unit cube, returns the samples
+// along with the origin and side of the cube in world coordinates
+func collectSamples(patches []*core.Patch, scale float64) (
+	samples []sample, origin [3]float64, side float64) {
+
+	minCorner := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	maxCorner := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	samples = make([]sample, 0, len(patches))
+	for _, patch := range patches {
+		if patch == nil || patch.Center == nil || patch.Normal == nil {
+			continue
+		}
+		var s sample
+		var norm float64
+		w := patch.Center.AtVec(3)
+		if w == 0 {
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			s.pos[i] = patch.Center.AtVec(i) / w
+			s.normal[i] = patch.Normal.AtVec(i)
+			norm += s.normal[i] * s.normal[i]
+			minCorner[i] = math.Min(minCorner[i], s.pos[i])
+			maxCorner[i] = math.Max(maxCorner[i], s.pos[i])
+		}
+		if norm == 0 {
+			continue
+		}
+		norm = math.Sqrt(norm)
+		for i := 0; i < 3; i++ {
+			s.normal[i] /= norm
+		}
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		side = math.Max(side, maxCorner[i]-minCorner[i])
+	}
+	if side == 0 {
+		side = 1
+	}
+	side *= scale
+	for i := 0; i < 3; i++ {
+		origin[i] = (minCorner[i]+maxCorner[i])/2 - side/2
+	}
+	for i := range samples {
+		for c := 0; c < 3; c++ {
+			samples[i].pos[c] = clampUnit((samples[i].pos[c] - origin[c]) / side)
+		}
+	}
+	return
+}
+
+// splat : Distributes a unit weight around p with a tent kernel of the given
+// radius in cells, calling add for every interior node it reaches
+func splat(lvl *level, p [3]float64, radius int, add func(i int, weight float64)) {
+	var center [3]float64
+	var lo, hi [3]int
+	for c := 0; c < 3; c++ {
+		center[c] = p[c] * float64(lvl.n)
+		lo[c] = int(math.Ceil(center[c] - float64(radius)))
+		hi[c] = int(math.Floor(center[c] + float64(radius)))
+		if lo[c] < 1 {
+			lo[c] = 1
+		}
+		if hi[c] > lvl.n-1 {
+			hi[c] = lvl.n - 1
+		}
+	}
+	tent := func(c, node int) float64 {
+		return math.Max(0, 1-math.Abs(float64(node)-center[c])/float64(radius))
+	}
+
+	var sum float64
+	for z := lo[2]; z <= hi[2]; z++ {
+		for y := lo[1]; y <= hi[1]; y++ {
+			for x := lo[0]; x <= hi[0]; x++ {
+				sum += tent(0, x) * tent(1, y) * tent(2, z)
+			}
+		}
+	}
+	if sum == 0 {
+		return
+	}
+	for z := lo[2]; z <= hi[2]; z++ {
+		for y := lo[1]; y <= hi[1]; y++ {
+			for x := lo[0]; x <= hi[0]; x++ {
+				weight := tent(0, x) * tent(1, y) * tent(2, z) / sum
+				if weight > 0 {
+					add(lvl.index(x, y, z), weight)
+				}
+			}
+		}
+	}
+}
+
+// trilinear : Interpolates a field defined on the nodes of lvl at the point
+// p of the unit cube
+func trilinear(lvl *level, field []float64, p [3]float64) float64 {
+	var base [3]int
+	var frac [3]float64
+	for c := 0; c < 3; c++ {
+		coord := p[c] * float64(lvl.n)
+		base[c] = int(coord)
+		if base[c] >= lvl.n {
+			base[c] = lvl.n - 1
+		}
+		frac[c] = coord - float64(base[c])
+	}
+	var val float64
+	for corner := 0; corner < 8; corner++ {
+		weight := 1.0
+		var node [3]int
+		for c := 0; c < 3; c++ {
+			if corner>>uint(c)&1 == 1 {
+				node[c] = base[c] + 1
+				weight *= frac[c]
+			} else {
+				node[c] = base[c]
+				weight *= 1 - frac[c]
+			}
+		}
+		val += weight * field[lvl.index(node[0], node[1], node[2])]
+	}
+	return val
+}
+
+// trimByDensity : Removes the fraction of vertices with the lowest density
+func trimByDensity(result *mesh.Mesh, lvl *level, density []float64,
+	origin [3]float64, side, fraction float64) {
+
+	if len(result.Vertices) == 0 {
+		return
+	}
+	vertexDensity := make([]float64, len(result.Vertices))
+	for i, vertex := range result.Vertices {
+		var p [3]float64
+		for c := 0; c < 3; c++ {
+			p[c] = clampUnit((vertex[c] - origin[c]) / side)
+		}
+		vertexDensity[i] = trilinear(lvl, density, p)
+	}
+	sorted := append([]float64(nil), vertexDensity...)
+	sort.Float64s(sorted)
+	cut := int(fraction * float64(len(sorted)))
+	if cut >= len(sorted) {
+		cut = len(sorted) - 1
+	}
+	threshold := sorted[cut]
+	result.KeepVertices(func(i int) bool {
+		return vertexDensity[i] >= threshold
+	})
+}
+
+func clampUnit(val float64) float64 {
+	return math.Max(0, math.Min(val, math.Nextafter(1, 0)))
+}
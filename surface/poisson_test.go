@@ -0,0 +1,75 @@
+package surface
+
+import (
+	"math"
+	"pmvs/core"
+	"pmvs/mesh"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// spherePatches : Returns patches evenly spread on the sphere of given
+// center and radius, with normals pointing outside
+func spherePatches(num int, center [3]float64, radius float64) []*core.Patch {
+	patches := make([]*core.Patch, num)
+	golden := math.Pi * (3 - math.Sqrt(5))
+	for i := range patches {
+		z := 1 - 2*(float64(i)+0.5)/float64(num)
+		ring := math.Sqrt(1 - z*z)
+		normal := [3]float64{ring * math.Cos(golden*float64(i)),
+			ring * math.Sin(golden*float64(i)), z}
+		patch := new(core.Patch)
+		patch.Center = mat.NewVecDense(4, []float64{
+			center[0] + radius*normal[0],
+			center[1] + radius*normal[1],
+			center[2] + radius*normal[2],
+			1,
+		})
+		patch.Normal = mat.NewVecDense(4, []float64{normal[0], normal[1], normal[2], 0})
+		patches[i] = patch
+	}
+	return patches
+}
+
+func TestPoissonReconstructSphere(t *testing.T) {
+	center := [3]float64{1, -2, 0.5}
+	radius := 2.0
+	options := NewPoissonOptions()
+	options.Depth = 5
+	result := PoissonReconstruct(spherePatches(3000, center, radius), options)
+	if len(result.Faces) == 0 {
+		t.Fatal("the mesh is empty")
+	}
+	checkClosedMesh(t, result)
+
+	cell := 2 * radius * options.Scale / float64(int(1)<<uint(options.Depth))
+	for _, vertex := range result.Vertices {
+		var sum float64
+		for i := 0; i < 3; i++ {
+			sum += (vertex[i] - center[i]) * (vertex[i] - center[i])
+		}
+		if distance := math.Sqrt(sum); math.Abs(distance-radius) > cell {
+			t.Fatalf("vertex %v at distance %v from the center, the radius is %v",
+				vertex, distance, radius)
+		}
+	}
+}
+
+// checkClosedMesh : Checks that every edge of the mesh is shared by exactly
+// two faces that traverse it in opposite directions
+func checkClosedMesh(t *testing.T, result *mesh.Mesh) {
+	t.Helper()
+	edges := make(map[[2]int]int)
+	for _, face := range result.Faces {
+		for i := 0; i < 3; i++ {
+			edges[[2]int{face[i], face[(i+1)%3]}]++
+		}
+	}
+	for edge, count := range edges {
+		if count != 1 || edges[[2]int{edge[1], edge[0]}] != 1 {
+			t.Fatalf("edge %v is used %v times and its opposite %v times",
+				edge, count, edges[[2]int{edge[1], edge[0]}])
+		}
+	}
+}